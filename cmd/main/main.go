@@ -14,6 +14,7 @@ import (
 	"github.com/boringcat/just-a-log-viewer/dirfiles"
 	"github.com/boringcat/just-a-log-viewer/docker"
 	"github.com/boringcat/just-a-log-viewer/journald"
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/boringcat/just-a-log-viewer/server"
 	"github.com/boringcat/just-a-log-viewer/web"
 )
@@ -27,6 +28,7 @@ var (
 	printVersion   *kingpin.CmdClause
 	globTest       *kingpin.CmdClause
 	compOpt        = server.CompressOpts{}
+	redactOpt      = server.RedactOpts{Rules: map[string]string{}}
 
 	version, buildDate, commit, goVersion, gitBranch string
 )
@@ -39,9 +41,12 @@ func parserArgs() string {
 	cmdServer.Flag("config", "配置文件路径").Short('c').ExistingFileVar(&dirfiles.ConfigFilePath)
 	cmdServer.Flag("listen", "监听地址").Default(":8514").Short('l').TCPVar(&listen)
 	cmdServer.Flag("systemd", "启用Systemd日志功能").BoolVar(&journald.Enabled)
-	cmdServer.Flag("systemd-unit-state", "获取systemd unit的state过滤").Default("running,exited,failed,dead").StringVar(&journald.SystemdUnitState)
+	cmdServer.Flag("systemd-unit-state", "systemd单元列表默认的state过滤，可被请求参数 state 覆盖").Default("running,exited,failed,dead").StringVar(&journald.SystemdUnitState)
+	cmdServer.Flag("systemd-parser", "Systemd日志默认解析器").EnumVar(&journald.DefaultParser, parser.SupportedTypes...)
 	cmdServer.Flag("docker", "启用Docker日志功能").BoolVar(&docker.Enabled)
 	cmdServer.Flag("docker-all-container", "列出所有docker容器").BoolVar(&docker.AllContainer)
+	cmdServer.Flag("docker-parser", "Docker日志默认解析器，可被容器标签 "+docker.ParserLabel+" 覆盖").EnumVar(&docker.DefaultParser, parser.SupportedTypes...)
+	cmdServer.Flag("docker-data-root", "本机Docker数据目录，守护进程不可用时直接读取其中的日志文件").Default("/var/lib/docker").StringVar(&docker.DataRoot)
 	cmdServer.Flag("buffer", "文件扫描缓冲区大小").Default("16KiB").BytesVar(&G_bufsize)
 	cmdServer.Flag("prefix", "HTTP服务前缀").StringVar(&prefix)
 	cmdServer.Flag("prefix-redirect", "启用前缀跳转").BoolVar(&prefixRedirect)
@@ -51,6 +56,12 @@ func parserArgs() string {
 	cmdServer.Flag("compress-br-level", "HTTP Brotil压缩等级").Default("6").IntVar(&compOpt.BrotilLevel)
 	cmdServer.Flag("compress-zstd-level", "HTTP Zstd压缩等级").Default("1").IntVar(&compOpt.ZstdLevel)
 
+	cmdServer.Flag("redact-preset", "启用内置脱敏规则").EnumsVar(&redactOpt.Presets, server.SupportedRedactPresets...)
+	cmdServer.Flag("redact-rule", "自定义脱敏规则，格式为 名称=正则").StringMapVar(&redactOpt.Rules)
+	cmdServer.Flag("redact-exempt-group", "不做脱敏的用户组").StringsVar(&redactOpt.ExemptGroups)
+	cmdServer.Flag("redact-group-header", "反向代理传入用户组的请求头").Default("X-Forwarded-Groups").StringVar(&redactOpt.GroupHeader)
+	cmdServer.Flag("redact-trusted-proxy", "可信反向代理的地址(CIDR)，只读取来自这些地址的用户组请求头").StringsVar(&redactOpt.TrustedProxies)
+
 	tools := app.Command("tools", "工具")
 	globTest = tools.Command("glob-test", "测试glob配置")
 	globTest.Flag("config", "配置文件路径").Short('c').Required().ExistingFileVar(&dirfiles.ConfigFilePath)
@@ -60,7 +71,11 @@ func parserArgs() string {
 	cmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 	if cmd == cmdServer.FullCommand() {
 		server.GlobalBufSize = int(G_bufsize)
+		docker.ConfigFilePath = dirfiles.ConfigFilePath
 		compOpt.Verify()
+		if err := redactOpt.Verify(); err != nil {
+			app.Fatalf("%s", err)
+		}
 	}
 
 	if debug != nil && *debug {
@@ -79,7 +94,7 @@ func serverMain() {
 		mux.HandleFunc("/", server.RedirectPrefix(prefix))
 		fmt.Println(prefixRedirect)
 	}
-	if err := http.ListenAndServe(listen.String(), server.NewCompressHandler(mux, &compOpt)); err != nil {
+	if err := http.ListenAndServe(listen.String(), server.NewCompressHandler(server.NewRedactHandler(mux, &redactOpt), &compOpt)); err != nil {
 		panic(err)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
@@ -75,8 +76,13 @@ func (l *RegexpLabel) UnmarshalYAML(value *yaml.Node) error {
 }
 
 type ConfigFile struct {
-	Paths  []string                `json:"paths" yaml:"paths"`
-	Labels map[string]*RegexpLabel `json:"labels" yaml:"labels"`
+	Paths      []string                `json:"paths" yaml:"paths"`
+	Labels     map[string]*RegexpLabel `json:"labels" yaml:"labels"`
+	Extractors LabelExtractors         `json:"extractors" yaml:"extractors"`
+	Encoding   string                  `json:"encoding" yaml:"encoding"`
+	Format     string                  `json:"format" yaml:"format"`
+	Parser     *parser.Config          `json:"parser" yaml:"parser"`
+	Multiline  *parser.Multiline       `json:"multiline" yaml:"multiline"`
 }
 
 type Config struct {
@@ -107,21 +113,31 @@ func ReadConfig(filename string) (confs *Config, err error) {
 		}
 	}
 	if confs != nil {
+		for _, conf := range confs.Files {
+			if _, err = GetEncoding(conf.Encoding); err != nil {
+				return nil, err
+			}
+			if _, err = NewLineParser(conf.Format); err != nil {
+				return nil, err
+			}
+			if _, err = parser.New(conf.Parser); err != nil {
+				return nil, err
+			}
+		}
 		return confs, nil
 	}
 	return nil, errors.Wrap(ErrUnsupportFormat, filename)
 }
 
 func (c *ConfigFile) GetKeyMap(fp string) (name string, labels map[string]string) {
-	labels = make(map[string]string)
-	name = filepath.Base(fp)
+	labels = map[string]string{NameKey: filepath.Base(fp)}
 	for key, conf := range c.Labels {
-		val := conf.GetString(fp)
-		if key == NameKey {
-			name = val
-		} else {
-			labels[key] = val
-		}
+		labels[key] = conf.GetString(fp)
+	}
+	for _, ext := range c.Extractors {
+		ext.Extract(fp, labels)
 	}
+	name = labels[NameKey]
+	delete(labels, NameKey)
 	return
 }
@@ -0,0 +1,183 @@
+package dirfiles
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingAuto = "auto"
+	// 自动检测时读取的文件头部长度
+	detectSize = 4096
+)
+
+var ErrUnsupportEncoding = errors.New("unsupported encoding")
+
+// TextEncoding 文件编码，Unit 为换行符所占字节数
+type TextEncoding struct {
+	Name      string
+	Unit      int
+	BigEndian bool
+	enc       encoding.Encoding
+}
+
+var (
+	EncodingUTF8    = &TextEncoding{Name: "utf-8", Unit: 1}
+	EncodingUTF16LE = &TextEncoding{Name: "utf-16le", Unit: 2, enc: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)}
+	EncodingUTF16BE = &TextEncoding{Name: "utf-16be", Unit: 2, BigEndian: true, enc: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)}
+	EncodingGB18030 = &TextEncoding{Name: "gb18030", Unit: 1, enc: simplifiedchinese.GB18030}
+)
+
+// GetEncoding 按名称获取编码，空字符串和 auto 返回 nil 表示需要自动检测
+func GetEncoding(name string) (*TextEncoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "", EncodingAuto:
+		return nil, nil
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "utf-16le", "utf16le", "utf-16", "utf16":
+		return EncodingUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return EncodingUTF16BE, nil
+	case "gb18030":
+		return EncodingGB18030, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnsupportEncoding, name)
+	}
+	if canonical, err := htmlindex.Name(enc); err == nil && strings.HasPrefix(canonical, "utf-16") {
+		return GetEncoding(canonical)
+	}
+	return &TextEncoding{Name: name, Unit: 1, enc: enc}, nil
+}
+
+// DetectEncoding 根据BOM和内容特征猜测编码，无法判断时按UTF-8处理
+func DetectEncoding(r io.Reader) *TextEncoding {
+	buf := make([]byte, detectSize)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xef, 0xbb, 0xbf}):
+		return EncodingUTF8
+	case bytes.HasPrefix(buf, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(buf, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+	if len(buf) < 2 {
+		return EncodingUTF8
+	}
+	var evenZero, oddZero int
+	for idx, b := range buf {
+		if b != 0 {
+			continue
+		}
+		if idx%2 == 0 {
+			evenZero++
+		} else {
+			oddZero++
+		}
+	}
+	// ASCII为主的UTF-16文本每两个字节就有一个0
+	half := len(buf) / 2
+	if oddZero > half*3/10 && evenZero < half/10 {
+		return EncodingUTF16LE
+	} else if evenZero > half*3/10 && oddZero < half/10 {
+		return EncodingUTF16BE
+	}
+	// 读取长度截断的多字节字符不影响判断
+	for trim := 0; trim < utf8.UTFMax && trim < len(buf); trim++ {
+		if utf8.Valid(buf[:len(buf)-trim]) {
+			return EncodingUTF8
+		}
+	}
+	return EncodingGB18030
+}
+
+func DetectFileEncoding(fp string) (*TextEncoding, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return DetectEncoding(fd), nil
+}
+
+func (e *TextEncoding) IsUTF8() bool {
+	return e.enc == nil
+}
+
+// Newline 该编码下的换行符
+func (e *TextEncoding) Newline() []byte {
+	if e.Unit == 1 {
+		return NEWLINE_LINUX
+	} else if e.BigEndian {
+		return []byte{0, '\n'}
+	}
+	return []byte{'\n', 0}
+}
+
+// NewReader 返回转码为UTF-8的Reader，会跳过开头的BOM
+func (e *TextEncoding) NewReader(r io.Reader) io.Reader {
+	if e.IsUTF8() {
+		return r
+	}
+	return transform.NewReader(r, unicode.BOMOverride(e.enc.NewDecoder()))
+}
+
+// LineDecoder 将按单字节换行符切分的原始内容重新按编码的换行符组合并转码。
+// UTF-16 中 U+xx0A 之类字符的一个字节也是 0x0A，必须按对齐的两字节换行符切分
+type LineDecoder struct {
+	enc     *TextEncoding
+	pending []byte
+}
+
+func (e *TextEncoding) NewLineDecoder() *LineDecoder {
+	return &LineDecoder{enc: e}
+}
+
+// Decode 输入去掉了换行符的原始内容，newline 表示其后是否有换行符，返回转码后的完整行。
+// 单字节编码的输入总是完整的行
+func (d *LineDecoder) Decode(text string, newline bool) []string {
+	if d.enc.IsUTF8() {
+		return []string{text}
+	}
+	if d.enc.Unit == 1 {
+		return []string{d.decode([]byte(text))}
+	}
+	d.pending = append(d.pending, text...)
+	if newline {
+		d.pending = append(d.pending, '\n')
+	}
+	sep := d.enc.Newline()
+	lines := []string{}
+	start := 0
+	for idx := 0; idx+d.enc.Unit <= len(d.pending); idx += d.enc.Unit {
+		if bytes.Equal(d.pending[idx:idx+d.enc.Unit], sep) {
+			lines = append(lines, strings.TrimSuffix(d.decode(d.pending[start:idx]), "\r"))
+			start = idx + d.enc.Unit
+		}
+	}
+	d.pending = append(d.pending[:0], d.pending[start:]...)
+	return lines
+}
+
+func (d *LineDecoder) decode(b []byte) string {
+	res, _, err := transform.Bytes(unicode.BOMOverride(d.enc.enc.NewDecoder()), b)
+	if err != nil {
+		return string(b)
+	}
+	return string(res)
+}
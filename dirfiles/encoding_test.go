@@ -0,0 +1,81 @@
+package dirfiles
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"golang.org/x/text/transform"
+)
+
+// splitRaw 模拟tail按单字节换行符切分原始内容，末尾没有换行符的部分单独返回
+func splitRaw(raw []byte) (texts []string, newlines []bool) {
+	for len(raw) > 0 {
+		idx := bytes.IndexByte(raw, '\n')
+		if idx < 0 {
+			return append(texts, string(raw)), append(newlines, false)
+		}
+		texts, newlines = append(texts, string(raw[:idx])), append(newlines, true)
+		raw = raw[idx+1:]
+	}
+	return texts, newlines
+}
+
+func TestLineDecoderUTF16(t *testing.T) {
+	tests := []struct {
+		name  string
+		enc   *TextEncoding
+		text  string
+		lines []string
+	}{
+		{"ascii le", EncodingUTF16LE, "hello\nworld\n", []string{"hello", "world"}},
+		{"ascii be", EncodingUTF16BE, "hello\r\nworld\r\n", []string{"hello", "world"}},
+		// 上 U+4E0A 小端编码为 0A 4E
+		{"U+4E0A le", EncodingUTF16LE, "上海\n下一行\n", []string{"上海", "下一行"}},
+		// 上 U+4E0A 大端编码为 4E 0A
+		{"U+4E0A be", EncodingUTF16BE, "天上\n人间\n", []string{"天上", "人间"}},
+		{"U+xx0A only le", EncodingUTF16LE, "上Ċਊ\nਊ\n", []string{"上Ċਊ", "ਊ"}},
+		{"U+xx0A only be", EncodingUTF16BE, "上Ċਊ\nਊ\n", []string{"上Ċਊ", "ਊ"}},
+		{"U+0A00 le", EncodingUTF16LE, "਀\n਀਀\n", []string{"਀", "਀਀"}},
+		{"empty lines le", EncodingUTF16LE, "\n\n上\n", []string{"", "", "上"}},
+		{"partial last line le", EncodingUTF16LE, "上\n未完", []string{"上"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, _, err := transform.Bytes(tt.enc.enc.NewEncoder(), []byte(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec := tt.enc.NewLineDecoder()
+			lines := []string{}
+			texts, newlines := splitRaw(raw)
+			for idx, text := range texts {
+				lines = append(lines, dec.Decode(text, newlines[idx])...)
+			}
+			if !slices.Equal(lines, tt.lines) {
+				t.Errorf("got %q, want %q", lines, tt.lines)
+			}
+		})
+	}
+}
+
+func TestLineDecoderPartialWrite(t *testing.T) {
+	raw, _, _ := transform.Bytes(EncodingUTF16LE.enc.NewEncoder(), []byte("上一行\n第二行\n"))
+	dec := EncodingUTF16LE.NewLineDecoder()
+	lines := []string{}
+	// 逐字节写入，每次读到文件末尾
+	for idx := range raw {
+		b := raw[idx : idx+1]
+		if b[0] == '\n' {
+			lines = append(lines, dec.Decode("", true)...)
+		} else {
+			lines = append(lines, dec.Decode(string(b), false)...)
+		}
+	}
+	if want := []string{"上一行", "第二行"}; !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+	if len(dec.pending) != 0 {
+		t.Errorf("pending %x", dec.pending)
+	}
+}
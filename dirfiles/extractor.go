@@ -0,0 +1,184 @@
+package dirfiles
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var templateFuncs = template.FuncMap{
+	"base":    filepath.Base,
+	"dir":     filepath.Dir,
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.ReplaceAll,
+}
+
+// LabelExtractor 从文件路径中提取标签，可读取前序步骤已生成的标签
+type LabelExtractor interface {
+	Extract(fp string, labels map[string]string)
+}
+
+// RegexpExtractor 用一个正则生成多个标签：命名分组直接作为标签，Labels 为 标签名 -> 替换模板
+type RegexpExtractor struct {
+	Regex  *regexp.Regexp
+	Source string
+	Labels map[string]string
+}
+
+func (e *RegexpExtractor) Extract(fp string, labels map[string]string) {
+	val := fp
+	if len(e.Source) > 0 {
+		val = labels[e.Source]
+	}
+	indexes := e.Regex.FindStringSubmatchIndex(val)
+	if indexes == nil {
+		return
+	}
+	for idx, name := range e.Regex.SubexpNames() {
+		if len(name) == 0 {
+			continue
+		}
+		if indexes[idx*2] < 0 {
+			labels[name] = ""
+		} else {
+			labels[name] = val[indexes[idx*2]:indexes[idx*2+1]]
+		}
+	}
+	for key, repl := range e.Labels {
+		labels[key] = string(e.Regex.ExpandString([]byte{}, repl, val, indexes))
+	}
+}
+
+// StaticExtractor 固定值标签
+type StaticExtractor map[string]string
+
+func (e StaticExtractor) Extract(fp string, labels map[string]string) {
+	for key, val := range e {
+		labels[key] = val
+	}
+}
+
+// TemplateExtractor 用 Go 模板根据已有标签生成新标签
+type TemplateExtractor map[string]*template.Template
+
+func (e TemplateExtractor) Extract(fp string, labels map[string]string) {
+	for key, tpl := range e {
+		buf := strings.Builder{}
+		if err := tpl.Execute(&buf, labels); err != nil {
+			continue
+		}
+		labels[key] = buf.String()
+	}
+}
+
+// DirExtractor 取第N级父目录名作为标签，1为文件所在目录
+type DirExtractor map[string]int
+
+func (e DirExtractor) Extract(fp string, labels map[string]string) {
+	for key, depth := range e {
+		dir := fp
+		for range depth {
+			dir = filepath.Dir(dir)
+		}
+		labels[key] = filepath.Base(dir)
+	}
+}
+
+// XattrExtractor 读取文件扩展属性作为标签
+type XattrExtractor map[string]string
+
+func (e XattrExtractor) Extract(fp string, labels map[string]string) {
+	for key, attr := range e {
+		if val, err := getXattr(fp, attr); err == nil {
+			labels[key] = val
+		}
+	}
+}
+
+type labelExtractorConf struct {
+	Regex    string            `json:"regex" yaml:"regex"`
+	Source   string            `json:"source" yaml:"source"`
+	Labels   map[string]string `json:"labels" yaml:"labels"`
+	Static   map[string]string `json:"static" yaml:"static"`
+	Template map[string]string `json:"template" yaml:"template"`
+	Dir      map[string]int    `json:"dir" yaml:"dir"`
+	Xattr    map[string]string `json:"xattr" yaml:"xattr"`
+}
+
+func (c *labelExtractorConf) compile() (LabelExtractor, error) {
+	var ext LabelExtractor
+	count := 0
+	if len(c.Regex) > 0 {
+		regex, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, err
+		}
+		ext = &RegexpExtractor{Regex: regex, Source: c.Source, Labels: c.Labels}
+		count++
+	}
+	if c.Static != nil {
+		ext = StaticExtractor(c.Static)
+		count++
+	}
+	if c.Template != nil {
+		tpls := TemplateExtractor{}
+		for key, text := range c.Template {
+			tpl, err := template.New(key).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			tpls[key] = tpl
+		}
+		ext = tpls
+		count++
+	}
+	if c.Dir != nil {
+		ext = DirExtractor(c.Dir)
+		count++
+	}
+	if c.Xattr != nil {
+		ext = XattrExtractor(c.Xattr)
+		count++
+	}
+	if count != 1 {
+		return nil, errors.Wrap(ErrUnsupportFormat, "label extractor")
+	}
+	return ext, nil
+}
+
+// LabelExtractors 按顺序执行的标签提取流水线
+type LabelExtractors []LabelExtractor
+
+func (l *LabelExtractors) compile(confs []*labelExtractorConf) error {
+	*l = make(LabelExtractors, len(confs))
+	for idx, conf := range confs {
+		ext, err := conf.compile()
+		if err != nil {
+			return err
+		}
+		(*l)[idx] = ext
+	}
+	return nil
+}
+
+func (l *LabelExtractors) UnmarshalJSON(data []byte) error {
+	confs := []*labelExtractorConf{}
+	if err := json.Unmarshal(data, &confs); err != nil {
+		return err
+	}
+	return l.compile(confs)
+}
+
+func (l *LabelExtractors) UnmarshalYAML(value *yaml.Node) error {
+	confs := []*labelExtractorConf{}
+	if err := value.Decode(&confs); err != nil {
+		return err
+	}
+	return l.compile(confs)
+}
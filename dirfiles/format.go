@@ -0,0 +1,147 @@
+package dirfiles
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatRaw = "raw"
+	// CRI 容器日志格式：<RFC3339Nano> <stdout|stderr> <P|F> <msg>
+	FormatCRI = "cri"
+)
+
+var ErrUnsupportLogFormat = errors.New("unsupported log format")
+
+// LineParser 逐行解析日志，返回 false 表示该行还不是完整的一条日志
+type LineParser interface {
+	Parse(line string) (*parser.Entry, bool)
+}
+
+// LineParserFactory 解析器是有状态的，每次读取都需要创建新的
+type LineParserFactory func() (LineParser, error)
+
+// NewLineParserFactory ml 不为 nil 时会在格式解析后合并多行事件
+func NewLineParserFactory(format string, ml *parser.Multiline) LineParserFactory {
+	return func() (LineParser, error) {
+		lp, err := NewLineParser(format)
+		if err != nil || ml == nil {
+			return lp, err
+		}
+		return &groupParser{inner: lp, g: ml.NewGrouper()}, nil
+	}
+}
+
+func NewLineParser(format string) (LineParser, error) {
+	switch format {
+	case "", FormatRaw:
+		return rawParser{}, nil
+	case FormatCRI:
+		return &criParser{}, nil
+	}
+	return nil, errors.Wrap(ErrUnsupportLogFormat, format)
+}
+
+type rawParser struct{}
+
+func (rawParser) Parse(line string) (*parser.Entry, bool) {
+	return &parser.Entry{Message: line}, true
+}
+
+type criPartial struct {
+	ts  time.Time
+	buf strings.Builder
+}
+
+// criParser 合并 P 标记的部分行，stdout 和 stderr 分开合并，时间取第一段的时间
+type criParser struct {
+	partial map[string]*criPartial
+}
+
+func (p *criParser) Parse(line string) (*parser.Entry, bool) {
+	cl, ok := parser.ParseCRILine(line)
+	if !ok {
+		return &parser.Entry{Message: line}, true
+	}
+	ts, msg := cl.Time, cl.Message
+	if cl.Tag == "P" {
+		if p.partial == nil {
+			p.partial = map[string]*criPartial{}
+		}
+		part, ok := p.partial[cl.Stream]
+		if !ok {
+			part = &criPartial{ts: ts}
+			p.partial[cl.Stream] = part
+		}
+		part.buf.WriteString(msg)
+		return nil, false
+	}
+	if part, ok := p.partial[cl.Stream]; ok {
+		part.buf.WriteString(msg)
+		ts, msg = part.ts, part.buf.String()
+		delete(p.partial, cl.Stream)
+	}
+	return &parser.Entry{
+		TimeStamp: float64(ts.UnixNano()) / 1e6,
+		Stream:    cl.Stream,
+		Message:   msg,
+	}, true
+}
+
+// groupParser 合并多行事件，只有下一个事件开始或者超时的时候才会输出
+type groupParser struct {
+	inner LineParser
+	g     *parser.Grouper
+}
+
+func (p *groupParser) Parse(line string) (*parser.Entry, bool) {
+	e, ok := p.inner.Parse(line)
+	if !ok {
+		return nil, false
+	}
+	if done := p.g.Add(e); done != nil {
+		return done, true
+	}
+	return nil, false
+}
+
+func (p *groupParser) Flush() []*parser.Entry {
+	return p.g.Flush()
+}
+
+func (p *groupParser) FlushStale() []*parser.Entry {
+	return p.g.FlushStale()
+}
+
+// ReadEntries 逐行读取并解析日志，fn 返回 false 时停止
+func ReadEntries(rd io.Reader, lp LineParser, fn func(*parser.Entry) bool) error {
+	br := bufio.NewReaderSize(rd, server.GlobalBufSize)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if e, ok := lp.Parse(line); ok && !fn(e) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if gp, ok := lp.(*groupParser); ok {
+		for _, e := range gp.Flush() {
+			if !fn(e) {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/boringcat/just-a-log-viewer/server"
 )
 
@@ -81,13 +82,109 @@ func GetTailOffset(r io.ReadSeeker, lines int64) (int64, error) {
 	return 0, nil
 }
 
+// GetTailOffsetWithEncoding 按编码的换行符计算偏移，UTF-16等宽字符编码按对齐的字符匹配换行
+func GetTailOffsetWithEncoding(r io.ReadSeeker, lines int64, enc *TextEncoding) (int64, error) {
+	if enc == nil || enc.Unit == 1 {
+		return GetTailOffset(r, lines)
+	}
+	unit := int64(enc.Unit)
+	newline := enc.Newline()
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	end -= end % unit
+	if end < 2*unit {
+		return 0, io.EOF
+	}
+	var count int64 = 0
+	buf := make([]byte, max(int64(server.GlobalBufSize)/unit, 1)*unit)
+	if _, err = r.Seek(end-unit, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err = io.ReadFull(r, buf[0:unit]); err != nil {
+		return 0, err
+	}
+	if bytes.Equal(buf[0:unit], newline) {
+		count--
+	}
+	offset := end
+	for count < lines && offset > 0 {
+		nr := min(int64(len(buf)), offset)
+		offset -= nr
+		if _, err = r.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err = io.ReadFull(r, buf[0:nr]); err != nil {
+			return 0, err
+		}
+		for idx := nr - unit; idx >= 0; idx -= unit {
+			if bytes.Equal(buf[idx:idx+unit], newline) {
+				count++
+				if count >= lines {
+					return offset + idx + unit, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// GetTailOffsetWithParser 按解析后的逻辑行计算偏移，返回从偏移处开始需要跳过的逻辑行数
+func GetTailOffsetWithParser(r io.ReadSeeker, lines int64, enc *TextEncoding, newLP LineParserFactory) (offset int64, skip int64, err error) {
+	var lp LineParser
+	if lp, err = newLP(); err != nil {
+		return
+	} else if _, ok := lp.(rawParser); ok {
+		offset, err = GetTailOffsetWithEncoding(r, lines, enc)
+		return
+	}
+	physical := lines + 1
+	for {
+		if offset, err = GetTailOffsetWithEncoding(r, physical, enc); err != nil {
+			return
+		}
+		if _, err = r.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+		if lp, err = newLP(); err != nil {
+			return
+		}
+		counter := parser.EventCounter{}
+		err = ReadEntries(enc.NewReader(r), lp, func(e *parser.Entry) bool {
+			counter.Add(e)
+			return true
+		})
+		if err != nil {
+			return
+		}
+		if counter.Complete >= lines || offset == 0 {
+			return offset, max(counter.Count-lines, 0), nil
+		}
+		physical *= 2
+	}
+}
+
 func GetTailOffsetByFileName(fp string, lines int64) (int64, error) {
+	return GetTailOffsetByFileNameWithEncoding(fp, lines, nil)
+}
+
+func GetTailOffsetByFileNameWithEncoding(fp string, lines int64, enc *TextEncoding) (int64, error) {
 	fd, err := os.Open(fp)
 	if err != nil {
 		return 0, err
 	}
 	defer fd.Close()
-	return GetTailOffset(fd, lines)
+	return GetTailOffsetWithEncoding(fd, lines, enc)
+}
+
+func GetTailOffsetByFileNameWithParser(fp string, lines int64, enc *TextEncoding, newLP LineParserFactory) (int64, int64, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+	return GetTailOffsetWithParser(fd, lines, enc, newLP)
 }
 
 func GetHash(name, path string, keys []string, labels map[string]string) string {
@@ -112,10 +209,14 @@ func DoGlobWalk(confs *Config) iter.Seq[*File] {
 				for _, file := range files {
 					name, labels := conf.GetKeyMap(file)
 					ok := yield(&File{
-						Path:   file,
-						Name:   name,
-						Labels: labels,
-						Hash:   GetHash(name, path, confs.Keys, labels),
+						Path:      file,
+						Name:      name,
+						Labels:    labels,
+						Hash:      GetHash(name, path, confs.Keys, labels),
+						Encoding:  conf.Encoding,
+						Format:    conf.Format,
+						Parser:    conf.Parser,
+						Multiline: conf.Multiline,
 					})
 					if !ok {
 						return
@@ -0,0 +1,132 @@
+package dirfiles
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+	sortFuncs       = map[string]func(a, b *File) int{
+		"name":  func(a, b *File) int { return strings.Compare(a.Name, b.Name) },
+		"size":  func(a, b *File) int { return cmp.Compare(a.Size, b.Size) },
+		"mtime": func(a, b *File) int { return a.ModTime.Compare(b.ModTime) },
+		"inode": func(a, b *File) int { return cmp.Compare(a.Inode, b.Inode) },
+		"owner": func(a, b *File) int { return strings.Compare(a.Owner, b.Owner) },
+		"rate":  func(a, b *File) int { return cmp.Compare(a.Rate, b.Rate) },
+	}
+)
+
+// ListQuery 文件列表的过滤和排序条件
+type ListQuery struct {
+	Sort           string
+	Desc           bool
+	ModifiedWithin time.Duration
+	MinSize        int64
+	MaxSize        int64
+	MinRate        float64
+	Owner          string
+	Growing        *bool
+	Selector       server.Selector
+}
+
+func invalidQuery(q url.Values, key string) error {
+	return errors.Wrapf(ErrInvalidQuery, "%s=%q", key, q.Get(key))
+}
+
+func ParseListQuery(q url.Values) (*ListQuery, error) {
+	lq := &ListQuery{Sort: q.Get("sort")}
+	if len(lq.Sort) > 0 {
+		if _, ok := sortFuncs[lq.Sort]; !ok {
+			return nil, invalidQuery(q, "sort")
+		}
+	}
+	switch order := q.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		lq.Desc = true
+	default:
+		return nil, invalidQuery(q, "order")
+	}
+	var err error
+	if q.Has("modified_within") {
+		if lq.ModifiedWithin, err = time.ParseDuration(q.Get("modified_within")); err != nil {
+			return nil, invalidQuery(q, "modified_within")
+		}
+	}
+	if q.Has("min_size") {
+		if lq.MinSize, err = units.ParseStrictBytes(q.Get("min_size")); err != nil {
+			return nil, invalidQuery(q, "min_size")
+		}
+	}
+	if q.Has("max_size") {
+		if lq.MaxSize, err = units.ParseStrictBytes(q.Get("max_size")); err != nil {
+			return nil, invalidQuery(q, "max_size")
+		}
+	}
+	if q.Has("min_rate") {
+		if lq.MinRate, err = strconv.ParseFloat(q.Get("min_rate"), 64); err != nil {
+			return nil, invalidQuery(q, "min_rate")
+		}
+	}
+	if q.Has("growing") {
+		growing, err := strconv.ParseBool(q.Get("growing"))
+		if err != nil {
+			return nil, invalidQuery(q, "growing")
+		}
+		lq.Growing = &growing
+	}
+	if q.Has("selector") {
+		if lq.Selector, err = server.ParseSelector(q.Get("selector")); err != nil {
+			return nil, err
+		}
+	}
+	lq.Owner = q.Get("owner")
+	return lq, nil
+}
+
+func (lq *ListQuery) Match(f *File) bool {
+	if !lq.Selector.MatchesFunc(f.Label) {
+		return false
+	}
+	if lq.ModifiedWithin > 0 && time.Since(f.ModTime) > lq.ModifiedWithin {
+		return false
+	}
+	if lq.MinSize > 0 && f.Size < lq.MinSize {
+		return false
+	}
+	if lq.MaxSize > 0 && f.Size > lq.MaxSize {
+		return false
+	}
+	if lq.MinRate > 0 && f.Rate < lq.MinRate {
+		return false
+	}
+	if len(lq.Owner) > 0 && f.Owner != lq.Owner {
+		return false
+	}
+	if lq.Growing != nil && f.Growing != *lq.Growing {
+		return false
+	}
+	return true
+}
+
+func (lq *ListQuery) SortFiles(files []*File) {
+	fn, ok := sortFuncs[lq.Sort]
+	if !ok {
+		return
+	}
+	slices.SortStableFunc(files, func(a, b *File) int {
+		if lq.Desc {
+			return fn(b, a)
+		}
+		return fn(a, b)
+	})
+}
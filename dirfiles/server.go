@@ -7,11 +7,14 @@ import (
 	"log/slog"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/boringcat/just-a-log-viewer/server"
 	"github.com/nxadm/tail"
 )
@@ -23,17 +26,39 @@ var (
 	ConfigFilePath  string
 )
 
+const (
+	listRescanInterval  = 10 * time.Minute
+	watchRescanInterval = 10 * time.Second
+	// tailFromEnd 从文件末尾开始监听，不输出历史日志
+	tailFromEnd = -1
+)
+
 type File struct {
-	Hash   string            `json:"hash"`
-	Name   string            `json:"name"`
-	Path   string            `json:"-"`
-	Labels map[string]string `json:"labels"`
+	Hash      string            `json:"hash"`
+	Name      string            `json:"name"`
+	Path      string            `json:"-"`
+	Labels    map[string]string `json:"labels"`
+	Size      int64             `json:"size"`
+	ModTime   time.Time         `json:"mtime"`
+	Inode     uint64            `json:"inode"`
+	Uid       uint32            `json:"uid"`
+	Owner     string            `json:"owner"`
+	Growing   bool              `json:"growing"`
+	Rate      float64           `json:"rate"` // 最近 rateWindow 内的平均写入速率，字节每秒
+	Encoding  string            `json:"encoding,omitempty"`
+	Format    string            `json:"format,omitempty"`
+	Parser    *parser.Config    `json:"-"`
+	Multiline *parser.Multiline `json:"-"`
+	statAt    time.Time
+	samples   []rateSample
 }
 
 type Server struct {
-	conf      *Config
-	fmap      *sync.Map
+	conf *Config
+	// 重新扫描时整体替换，读取不需要加锁
+	fmap      atomic.Pointer[sync.Map]
 	lastFetch time.Time
+	statLock  sync.Mutex
 }
 
 func NewServer() (server.LogServer, error) {
@@ -43,22 +68,47 @@ func NewServer() (server.LogServer, error) {
 	confs, err := ReadConfig(ConfigFilePath)
 	if err != nil {
 		return nil, err
+	} else if len(confs.Files) == 0 {
+		// 配置文件中只有其他模块的配置
+		return nil, nil
 	}
-	s := Server{conf: confs}
-	go s.doGlobWalk()
-	return &s, nil
+	s := &Server{conf: confs}
+	s.fmap.Store(&sync.Map{})
+	go s.doGlobWalk(listRescanInterval)
+	return s, nil
 }
 
-func (s *Server) doGlobWalk() {
-	if time.Since(s.lastFetch) < 10*time.Minute {
+func (s *Server) doGlobWalk(maxAge time.Duration) {
+	s.statLock.Lock()
+	defer s.statLock.Unlock()
+	if time.Since(s.lastFetch) < maxAge {
 		return
 	}
 	s.lastFetch = time.Now()
-	var newMap sync.Map
+	oldMap, newMap := s.fmap.Load(), &sync.Map{}
 	for f := range DoGlobWalk(s.conf) {
+		if old, ok := oldMap.Load(f.Hash); ok && old.(*File).Path == f.Path {
+			f.copyStat(old.(*File))
+		}
 		newMap.Store(f.Hash, f)
 	}
-	s.fmap = &newMap
+	s.fmap.Store(newMap)
+}
+
+func (s *Server) listFiles() []*File {
+	files := []*File{}
+	s.statLock.Lock()
+	defer s.statLock.Unlock()
+	s.fmap.Load().Range(func(key, value any) bool {
+		f := value.(*File)
+		if err := f.UpdateStat(); err != nil {
+			slog.Debug("获取文件信息失败", "path", f.Path, "err", err)
+		}
+		snapshot := *f
+		files = append(files, &snapshot)
+		return true
+	})
+	return files
 }
 
 func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
@@ -66,7 +116,14 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 		server.HTTPError(w, http.StatusMethodNotAllowed)
 		return
 	}
-	s.doGlobWalk()
+	lq, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.doGlobWalk(listRescanInterval)
+	files := s.listFiles()
+	lq.SortFiles(files)
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
@@ -74,25 +131,96 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(s.conf.Keys)
 	fmt.Fprint(w, `,"files":`)
 	sep := "["
-	s.fmap.Range(func(key, value any) bool {
+	for _, f := range files {
+		if !lq.Match(f) {
+			continue
+		}
 		fmt.Fprint(w, sep)
-		enc.Encode(value)
+		enc.Encode(f)
 		sep = ","
-		return true
-	})
+	}
 	if sep == "[" {
 		fmt.Fprint(w, sep)
 	}
 	fmt.Fprint(w, "]}")
 }
 
-func (s *Server) getFile(h string) (string, error) {
+// Label 获取标签值，__name__ 对应文件名
+func (f *File) Label(key string) string {
+	if key == NameKey {
+		return f.Name
+	}
+	return f.Labels[key]
+}
+
+func (s *Server) getFile(h string) (*File, error) {
 	slog.Debug("查询文件", "hash", h)
-	val, ok := s.fmap.Load(h)
+	val, ok := s.fmap.Load().Load(h)
 	if !ok {
-		return "", os.ErrNotExist
+		return nil, os.ErrNotExist
+	}
+	return val.(*File), nil
+}
+
+func (s *Server) selectFiles(sel server.Selector) []*File {
+	files := []*File{}
+	s.fmap.Load().Range(func(key, value any) bool {
+		if f := value.(*File); sel.MatchesFunc(f.Label) {
+			files = append(files, f)
+		}
+		return true
+	})
+	return files
+}
+
+// findFile 通过 h 或 selector 查询唯一的文件，返回失败时的HTTP状态码
+func (s *Server) findFile(q url.Values) (*File, int, error) {
+	if q.Has("h") {
+		f, err := s.getFile(q.Get("h"))
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+		return f, http.StatusOK, nil
+	}
+	if !q.Has("selector") {
+		return nil, http.StatusBadRequest, server.EnsureKeys(q, "h")
+	}
+	sel, err := server.ParseSelector(q.Get("selector"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	slog.Debug("查询文件", "selector", sel)
+	files := s.selectFiles(sel)
+	switch len(files) {
+	case 0:
+		return nil, http.StatusNotFound, os.ErrNotExist
+	case 1:
+		return files[0], http.StatusOK, nil
+	default:
+		return nil, http.StatusConflict, fmt.Errorf("selector %s matches %d files", sel, len(files))
+	}
+}
+
+// newLineParserFactory 请求参数中的多行规则优先于配置
+func newLineParserFactory(f *File, q url.Values) (LineParserFactory, error) {
+	ml, err := parser.MultilineFromQuery(q, f.Multiline)
+	if err != nil {
+		return nil, err
+	}
+	return NewLineParserFactory(f.Format, ml), nil
+}
+
+// getEncoding 获取文件编码，请求参数 encoding 优先于配置
+func getEncoding(f *File, q url.Values) (*TextEncoding, error) {
+	name := f.Encoding
+	if q.Has("encoding") {
+		name = q.Get("encoding")
 	}
-	return val.(*File).Path, nil
+	enc, err := GetEncoding(name)
+	if err != nil || enc != nil {
+		return enc, err
+	}
+	return DetectFileEncoding(f.Path)
 }
 
 func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
@@ -101,22 +229,19 @@ func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "h"); err != nil {
-		server.HTTPError(w, http.StatusBadRequest)
+	f, code, err := s.findFile(q)
+	if err != nil {
+		http.Error(w, err.Error(), code)
 		return
 	}
-	fpath, err := s.getFile(q.Get("h"))
+	fpath := f.Path
+	enc, err := getEncoding(f, q)
 	if err != nil {
-		server.HTTPError(w, http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var tail_ int64 = 1000
-	if q.Has("tail") {
-		if val, err := strconv.ParseInt(q.Get("tail"), 10, 64); err == nil {
-			tail_ = val
-		}
-	}
+	tail_ := parseTailLines(q)
 	var contentType string
 	for _, ext := range GetExts(fpath) {
 		contentType = mime.TypeByExtension(ext)
@@ -126,6 +251,31 @@ func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(contentType) == 0 {
 		contentType = "text/plain; charset=utf-8"
+	} else if mediatype, params, err := mime.ParseMediaType(contentType); err == nil && len(params["charset"]) > 0 {
+		// 输出已转码为UTF-8
+		params["charset"] = "utf-8"
+		contentType = mime.FormatMediaType(mediatype, params)
+	}
+
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, err := parser.FromQuery(q, f.Parser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newLP, err := newLineParserFactory(f, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lp, err := newLP()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	fd, err := os.Open(fpath)
@@ -134,10 +284,10 @@ func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer fd.Close()
-	var offset int64 = 0
+	var offset, skip int64 = 0, 0
 
 	if tail_ > 0 {
-		offset, err = GetTailOffset(fd, tail_)
+		offset, skip, err = GetTailOffsetWithParser(fd, tail_, enc, newLP)
 		if err == io.EOF {
 			http.NotFound(w, r)
 			return
@@ -147,8 +297,68 @@ func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 		}
 		fd.Seek(offset, io.SeekStart)
 	}
-	w.Header().Set("Content-Type", contentType)
-	io.CopyBuffer(w, fd, make([]byte, server.GlobalBufSize))
+	if _, ok := lp.(rawParser); ok && ew.Raw() && pipeline.Parser == nil && len(pipeline.Filter) == 0 {
+		w.Header().Set("Content-Type", contentType)
+		io.CopyBuffer(w, enc.NewReader(fd), make([]byte, server.GlobalBufSize))
+		return
+	}
+	w.Header().Set("Content-Type", ew.ContentType())
+	ReadEntries(enc.NewReader(fd), lp, func(e *parser.Entry) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if !pipeline.Apply(e) {
+			return true
+		}
+		return ew.Write(e) == nil
+	})
+}
+
+func parseTailLines(q url.Values) int64 {
+	var tail_ int64 = 1000
+	if q.Has("tail") {
+		if val, err := strconv.ParseInt(q.Get("tail"), 10, 64); err == nil {
+			tail_ = val
+		}
+	}
+	return tail_
+}
+
+// tailFile 开始监听文件，返回的 entryReader 会跳过偏移处多读的日志。tail_ 为 tailFromEnd 时从文件末尾开始
+func tailFile(f *File, tail_ int64, enc *TextEncoding, newLP LineParserFactory, pipeline *parser.Pipeline) (*tail.Tail, *entryReader, error) {
+	// UTF-16 的换行符由 LineDecoder 组合，需要及时拿到换行符之后的字节
+	tc := tail.Config{Follow: true, CompleteLines: enc.Unit == 1}
+	lp, err := newLP()
+	if err != nil {
+		return nil, nil, err
+	}
+	er := &entryReader{dec: enc.NewLineDecoder(), lp: lp, pipeline: pipeline}
+	if tail_ > 0 {
+		offset, skip, err := GetTailOffsetByFileNameWithParser(f.Path, tail_, enc, newLP)
+		if err != nil {
+			return nil, nil, err
+		}
+		tc.Location = &tail.SeekInfo{
+			Offset: offset,
+			Whence: io.SeekStart,
+		}
+		er.skip = skip
+		er.offset = offset
+	} else if tail_ == tailFromEnd {
+		// 记录绝对偏移，UTF-16 需要据此判断换行符
+		st, err := os.Stat(f.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		tc.Location = &tail.SeekInfo{Offset: st.Size(), Whence: io.SeekStart}
+		er.offset = st.Size()
+	}
+	t, err := tail.TailFile(f.Path, tc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, er, nil
 }
 
 func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
@@ -157,13 +367,33 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "h"); err != nil {
-		server.HTTPError(w, http.StatusBadRequest)
+	if q.Has("selector") && !q.Has("h") {
+		s.handleWatchSelector(w, r)
 		return
 	}
-	fpath, err := s.getFile(q.Get("h"))
+	f, code, err := s.findFile(q)
 	if err != nil {
-		server.HTTPError(w, http.StatusNotFound)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	enc, err := getEncoding(f, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, err := parser.FromQuery(q, f.Parser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newLP, err := newLineParserFactory(f, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -173,25 +403,7 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var tail_ int64 = 1000
-	if q.Has("tail") {
-		if val, err := strconv.ParseInt(q.Get("tail"), 10, 64); err == nil {
-			tail_ = val
-		}
-	}
-	tc := tail.Config{Follow: true}
-	if tail_ > 0 {
-		offset, err := GetTailOffsetByFileName(fpath, tail_)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		tc.Location = &tail.SeekInfo{
-			Offset: offset,
-			Whence: io.SeekStart,
-		}
-	}
-	t, err := tail.TailFile(fpath, tc)
+	t, er, err := tailFile(f, parseTailLines(q), enc, newLP, pipeline)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -202,10 +414,19 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	flusher.Flush()
 
+	flushTicker := er.NewFlushTicker()
+	defer flushTicker.Stop()
 	for {
 		select {
 		case line := <-t.Lines:
-			fmt.Fprintf(w, "data: %s\n\n", line.Text)
+			for _, e := range er.Read(line) {
+				ew.WriteEvent("", e)
+			}
+			flusher.Flush()
+		case <-flushTicker.C:
+			for _, e := range er.FlushStale() {
+				ew.WriteEvent("", e)
+			}
 			flusher.Flush()
 		case <-r.Context().Done():
 			return
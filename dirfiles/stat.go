@@ -0,0 +1,67 @@
+package dirfiles
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	// 两次采样间隔小于该值时不重新计算写入速率
+	minRateInterval = time.Second
+	// 最后修改时间在该时间内的文件视为正在写入
+	growingWindow = 30 * time.Second
+	// 写入速率按该时间窗口内的大小变化计算
+	rateWindow = time.Minute
+)
+
+// rateSample 某次采样时的文件大小
+type rateSample struct {
+	at   time.Time
+	size int64
+}
+
+// UpdateStat 刷新文件元数据，并根据 rateWindow 内的采样计算写入速率
+func (f *File) UpdateStat() error {
+	now := time.Now()
+	if !f.statAt.IsZero() && now.Sub(f.statAt) < minRateInterval {
+		return nil
+	}
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	inode, uid := getInodeAndUid(fi)
+	// 文件被轮转或截断时重新开始计算速率
+	if inode != f.Inode || fi.Size() < f.Size {
+		f.samples = nil
+	}
+	f.samples = append(f.samples, rateSample{at: now, size: fi.Size()})
+	// 保留一个窗口开始前的采样作为基准，采样间隔超过窗口时基准为上一次采样
+	for len(f.samples) > 2 && now.Sub(f.samples[1].at) >= rateWindow {
+		f.samples = f.samples[1:]
+	}
+	f.Rate = 0
+	if base := f.samples[0]; len(f.samples) > 1 {
+		f.Rate = float64(fi.Size()-base.size) / now.Sub(base.at).Seconds()
+	}
+	f.Size = fi.Size()
+	f.ModTime = fi.ModTime()
+	f.Inode = inode
+	f.Uid = uid
+	f.Owner = lookupOwner(uid)
+	f.Growing = f.Rate > 0 || now.Sub(f.ModTime) < growingWindow
+	f.statAt = now
+	return nil
+}
+
+func (f *File) copyStat(old *File) {
+	f.Size = old.Size
+	f.ModTime = old.ModTime
+	f.Inode = old.Inode
+	f.Uid = old.Uid
+	f.Owner = old.Owner
+	f.Growing = old.Growing
+	f.Rate = old.Rate
+	f.statAt = old.statAt
+	f.samples = old.samples
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package dirfiles
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var owners sync.Map
+
+func getInodeAndUid(fi os.FileInfo) (uint64, uint32) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino, st.Uid
+	}
+	return 0, 0
+}
+
+func lookupOwner(uid uint32) string {
+	if val, ok := owners.Load(uid); ok {
+		return val.(string)
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	owners.Store(uid, name)
+	return name
+}
+
+func getXattr(fp, attr string) (string, error) {
+	sz, err := syscall.Getxattr(fp, attr, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, sz)
+	if sz, err = syscall.Getxattr(fp, attr, buf); err != nil {
+		return "", err
+	}
+	return string(buf[:sz]), nil
+}
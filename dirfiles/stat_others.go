@@ -0,0 +1,20 @@
+//go:build !linux
+
+package dirfiles
+
+import (
+	"errors"
+	"os"
+)
+
+func getInodeAndUid(fi os.FileInfo) (uint64, uint32) {
+	return 0, 0
+}
+
+func lookupOwner(uid uint32) string {
+	return ""
+}
+
+func getXattr(fp, attr string) (string, error) {
+	return "", errors.ErrUnsupported
+}
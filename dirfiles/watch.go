@@ -0,0 +1,227 @@
+package dirfiles
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/nxadm/tail"
+)
+
+// watchedFile 选择器监听中的单个文件，不再匹配时取消转发并停止tail
+type watchedFile struct {
+	t      *tail.Tail
+	cancel context.CancelFunc
+}
+
+func (wf *watchedFile) Stop() {
+	wf.cancel()
+	wf.t.Stop()
+}
+
+type watchLine struct {
+	prefix string
+	entry  *parser.Entry
+}
+
+// entryReader 将tail读到的原始行转码、解析为日志
+type entryReader struct {
+	dec      *LineDecoder
+	lp       LineParser
+	pipeline *parser.Pipeline
+	skip     int64
+	labels   map[string]string
+	// 已读取到的文件偏移，用于判断tail返回的行后是否有换行符
+	offset int64
+}
+
+func (er *entryReader) emit(entries []*parser.Entry, e *parser.Entry) []*parser.Entry {
+	if er.skip > 0 {
+		er.skip--
+		return entries
+	}
+	e.Labels = er.labels
+	if er.pipeline.Apply(e) {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (er *entryReader) Read(line *tail.Line) []*parser.Entry {
+	// 不要求完整行时，文件末尾未完成的内容也会返回，此时偏移只增加内容的长度
+	if line.SeekInfo.Offset < er.offset {
+		// 文件被截断后从头读取
+		er.offset = 0
+	}
+	newline := line.SeekInfo.Offset-er.offset > int64(len(line.Text))
+	er.offset = line.SeekInfo.Offset
+	entries := []*parser.Entry{}
+	for _, line := range er.dec.Decode(line.Text, newline) {
+		if e, ok := er.lp.Parse(line); ok {
+			entries = er.emit(entries, e)
+		}
+	}
+	return entries
+}
+
+// FlushStale 输出超时未完成的多行事件
+func (er *entryReader) FlushStale() []*parser.Entry {
+	entries := []*parser.Entry{}
+	if gp, ok := er.lp.(*groupParser); ok {
+		for _, e := range gp.FlushStale() {
+			entries = er.emit(entries, e)
+		}
+	}
+	return entries
+}
+
+// NewFlushTicker 没有多行规则时返回不会触发的 Ticker
+func (er *entryReader) NewFlushTicker() *time.Ticker {
+	if gp, ok := er.lp.(*groupParser); ok && gp.g.Timeout() > 0 {
+		return time.NewTicker(gp.g.Timeout() / 2)
+	}
+	t := time.NewTicker(time.Hour)
+	t.Stop()
+	return t
+}
+
+// labelPrefix 按 Keys 顺序生成行前缀，如 {命名空间="prod",Pod="web-1"}
+func (f *File) labelPrefix(keys []string) string {
+	buf := strings.Builder{}
+	buf.WriteByte('{')
+	for idx, k := range keys {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=%q", k, f.Label(k))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func forwardLines(ctx context.Context, t *tail.Tail, er *entryReader, prefix string, lines chan<- watchLine) {
+	flushTicker := er.NewFlushTicker()
+	defer flushTicker.Stop()
+	for {
+		var entries []*parser.Entry
+		select {
+		case line, ok := <-t.Lines:
+			if !ok {
+				return
+			}
+			entries = er.Read(line)
+		case <-flushTicker.C:
+			entries = er.FlushStale()
+		case <-ctx.Done():
+			return
+		}
+		for _, e := range entries {
+			select {
+			case lines <- watchLine{prefix: prefix, entry: e}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// handleWatchSelector 同时监听所有匹配选择器的文件，定期重新扫描，加入新匹配的文件，停止不再匹配或已删除的文件
+func (s *Server) handleWatchSelector(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sel, err := server.ParseSelector(q.Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		server.HTTPError(w, http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	lines := make(chan watchLine)
+	watched := map[string]*watchedFile{}
+	defer func() {
+		for _, wf := range watched {
+			wf.Stop()
+		}
+	}()
+	tail_ := parseTailLines(q)
+	// 监听过程中新匹配的文件从末尾开始，不输出历史日志
+	updateFiles := func(tailLines int64) {
+		files := s.selectFiles(sel)
+		matched := make(map[string]bool, len(files))
+		for _, f := range files {
+			matched[f.Path] = true
+		}
+		for path, wf := range watched {
+			if !matched[path] {
+				slog.Debug("移除监听文件", "path", path, "selector", sel)
+				wf.Stop()
+				delete(watched, path)
+			}
+		}
+		for _, f := range files {
+			if _, ok := watched[f.Path]; ok {
+				continue
+			}
+			enc, err := getEncoding(f, q)
+			if err != nil {
+				slog.Warn("获取文件编码失败", "path", f.Path, "err", err)
+				continue
+			}
+			pipeline, err := parser.FromQuery(q, f.Parser)
+			if err != nil {
+				slog.Warn("创建日志解析器失败", "path", f.Path, "err", err)
+				continue
+			}
+			newLP, err := newLineParserFactory(f, q)
+			if err != nil {
+				slog.Warn("创建日志解析器失败", "path", f.Path, "err", err)
+				continue
+			}
+			t, er, err := tailFile(f, tailLines, enc, newLP, pipeline)
+			if err != nil {
+				slog.Warn("监听文件失败", "path", f.Path, "err", err)
+				continue
+			}
+			slog.Debug("加入监听文件", "path", f.Path, "selector", sel)
+			fctx, cancel := context.WithCancel(ctx)
+			watched[f.Path] = &watchedFile{t: t, cancel: cancel}
+			er.labels = f.Labels
+			go forwardLines(fctx, t, er, f.labelPrefix(s.conf.Keys)+" ", lines)
+		}
+	}
+	updateFiles(tail_)
+
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(watchRescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-lines:
+			ew.WriteEvent(line.prefix, line.entry)
+			flusher.Flush()
+		case <-ticker.C:
+			s.doGlobWalk(watchRescanInterval)
+			updateFiles(tailFromEnd)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
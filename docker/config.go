@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultEndpoint = "default"
+
+var ErrInvalidEndpoint = errors.New("invalid endpoint")
+
+type EndpointTLS struct {
+	CA                 string `json:"ca" yaml:"ca"`
+	Cert               string `json:"cert" yaml:"cert"`
+	Key                string `json:"key" yaml:"key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// EndpointConfig 一个docker守护进程的连接方式，Host 为空时使用 DOCKER_HOST 等环境变量
+type EndpointConfig struct {
+	Name string       `json:"name" yaml:"name"`
+	Host string       `json:"host" yaml:"host"`
+	TLS  *EndpointTLS `json:"tls" yaml:"tls"`
+	// 连接池大小，0 为不限制
+	MaxConns     int `json:"max_conns" yaml:"max_conns"`
+	MaxIdleConns int `json:"max_idle_conns" yaml:"max_idle_conns"`
+	// docker 数据目录，守护进程不可用时直接读取其中的日志文件，本机连接默认使用 --docker-data-root
+	DataRoot string `json:"data_root" yaml:"data_root"`
+}
+
+// Config 配置文件中的 docker 部分
+type Config struct {
+	Endpoints []*EndpointConfig `json:"endpoints" yaml:"endpoints"`
+	// 所有容器都允许读取的容器内日志目录
+	Files []string `json:"files" yaml:"files"`
+}
+
+type fileConfig struct {
+	Docker *Config `json:"docker" yaml:"docker"`
+}
+
+// ReadConfig 读取配置文件的 docker 部分，没有配置时返回只有默认连接的配置
+func ReadConfig(filename string) (*Config, error) {
+	fc := &fileConfig{}
+	if len(filename) > 0 {
+		fd, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer fd.Close()
+		if strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml") {
+			err = yaml.NewDecoder(fd).Decode(fc)
+		} else if strings.HasSuffix(filename, ".json") {
+			err = json.NewDecoder(fd).Decode(fc)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fc.Docker == nil || len(fc.Docker.Endpoints) == 0 {
+		conf := &Config{Endpoints: []*EndpointConfig{{Name: DefaultEndpoint}}}
+		if fc.Docker != nil {
+			conf.Files = fc.Docker.Files
+		}
+		return conf, nil
+	}
+	names := map[string]bool{}
+	for _, ep := range fc.Docker.Endpoints {
+		if len(ep.Name) == 0 || strings.ContainsAny(ep.Name, "/ ") {
+			return nil, errors.Wrapf(ErrInvalidEndpoint, "name %q", ep.Name)
+		} else if names[ep.Name] {
+			return nil, errors.Wrapf(ErrInvalidEndpoint, "duplicate name %q", ep.Name)
+		}
+		names[ep.Name] = true
+	}
+	return fc.Docker, nil
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/pkg/errors"
+)
+
+const (
+	pingTimeout = 5 * time.Second
+	// 健康检查结果的有效期，期间直接使用已有连接
+	healthTTL = 10 * time.Second
+)
+
+// Health 最近一次连接检查的结果
+type Health struct {
+	Healthy   bool      `json:"healthy"`
+	Engine    string    `json:"engine,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Endpoint 每个连接有独立的连接池和健康状态
+type Endpoint struct {
+	conf   *EndpointConfig
+	client *client.Client
+	health Health
+	lock   sync.Mutex
+	// 正在检查连接时不为 nil，检查结束后关闭
+	checking chan struct{}
+	// 通过版本接口识别，兼容接口中没有pod等信息
+	podman bool
+}
+
+func NewEndpoint(conf *EndpointConfig) *Endpoint {
+	return &Endpoint{conf: conf}
+}
+
+func (ep *Endpoint) Name() string {
+	return ep.conf.Name
+}
+
+func (ep *Endpoint) newClient() (*client.Client, error) {
+	conf := ep.conf
+	transport := &http.Transport{
+		MaxConnsPerHost:     conf.MaxConns,
+		MaxIdleConnsPerHost: conf.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	opts := []client.Opt{client.WithHTTPClient(&http.Client{Transport: transport})}
+	switch {
+	case len(conf.Host) == 0:
+		opts = append(opts, client.FromEnv)
+	case strings.HasPrefix(conf.Host, "ssh://"):
+		dialer, err := sshDialer(conf.Host)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithHost("http://docker.example.com"), client.WithDialContext(dialer))
+	default:
+		opts = append(opts, client.WithHost(conf.Host))
+	}
+	if conf.TLS != nil {
+		tlsConf, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             conf.TLS.CA,
+			CertFile:           conf.TLS.Cert,
+			KeyFile:            conf.TLS.Key,
+			InsecureSkipVerify: conf.TLS.InsecureSkipVerify,
+			ExclusiveRootPools: true,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, conf.Name)
+		}
+		transport.TLSClientConfig = tlsConf
+	}
+	opts = append(opts, client.WithAPIVersionNegotiation())
+	return client.NewClientWithOpts(opts...)
+}
+
+// check 不持有锁检查连接，已有连接失效时关闭并重新连接一次
+func (ep *Endpoint) check(ctx context.Context, cli *client.Client) (*client.Client, error) {
+	if cli != nil {
+		if err := ep.ping(ctx, cli, false); err == nil {
+			return cli, nil
+		}
+		cli.Close()
+	}
+	cli, err := ep.newClient()
+	if err != nil {
+		ep.setHealth(Health{Error: err.Error(), CheckedAt: time.Now()})
+		return nil, err
+	}
+	if err = ep.ping(ctx, cli, true); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// ping 检查连接并记录健康状态，detect 为 true 时重新识别引擎类型
+func (ep *Endpoint) ping(ctx context.Context, cli *client.Client, detect bool) error {
+	// 守护进程卡住时连接不会失败，需要超时
+	pctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	ping, err := cli.Ping(pctx)
+	if err != nil {
+		ep.setHealth(Health{Error: err.Error(), CheckedAt: time.Now()})
+		return err
+	}
+	podman := ep.isPodman()
+	if detect {
+		podman = ep.detectEngine(ctx, cli)
+	}
+	health := Health{Healthy: true, Engine: EngineDocker, Version: ping.APIVersion, CheckedAt: time.Now()}
+	if podman {
+		health.Engine = EnginePodman
+	}
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	ep.podman, ep.health = podman, health
+	return nil
+}
+
+func (ep *Endpoint) setHealth(health Health) {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	ep.health = health
+}
+
+// detectEngine Podman 的版本信息中有名为 Podman Engine 的组件
+func (ep *Endpoint) detectEngine(ctx context.Context, cli *client.Client) bool {
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		slog.Debug("获取docker版本失败", "endpoint", ep.Name(), "err", err)
+		return false
+	}
+	podman := false
+	for _, c := range version.Components {
+		if strings.Contains(c.Name, "Podman") {
+			podman = true
+		}
+	}
+	slog.Debug("连接docker", "endpoint", ep.Name(), "podman", podman, "version", version.Version)
+	return podman
+}
+
+// local 通过unix socket连接时，容器的journald日志在本机
+func (ep *Endpoint) local() bool {
+	host := ep.conf.Host
+	if len(host) == 0 {
+		if host = os.Getenv(client.EnvOverrideHost); len(host) == 0 {
+			return true
+		}
+	}
+	return strings.HasPrefix(host, "unix://")
+}
+
+// dataRoot 直接读取日志文件使用的数据目录，Podman 的存储结构不同，不支持
+func (ep *Endpoint) dataRoot() string {
+	if len(ep.conf.DataRoot) > 0 {
+		return ep.conf.DataRoot
+	} else if ep.local() && !ep.isPodman() {
+		return DataRoot
+	}
+	return ""
+}
+
+func (ep *Endpoint) isPodman() bool {
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	return ep.podman
+}
+
+// apiURL 拼接 libpod 等docker客户端没有封装的接口地址，unix socket 和 ssh 连接时主机名不起作用
+func (ep *Endpoint) apiURL(cli *client.Client, path string) string {
+	host := cli.DaemonHost()
+	switch {
+	case strings.HasPrefix(host, "tcp://") && ep.conf.TLS != nil:
+		return "https://" + strings.TrimPrefix(host, "tcp://") + path
+	case strings.HasPrefix(host, "tcp://"):
+		return "http://" + strings.TrimPrefix(host, "tcp://") + path
+	case strings.HasPrefix(host, "http://"), strings.HasPrefix(host, "https://"):
+		return strings.TrimSuffix(host, "/") + path
+	}
+	return "http://d" + path
+}
+
+// listPods 通过 libpod 接口获取容器所属的pod，返回容器ID到pod名称的映射
+func (ep *Endpoint) listPods(ctx context.Context, cli *client.Client) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.apiURL(cli, "/v4.0.0/libpod/pods/json"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cli.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list pods: %s", resp.Status)
+	}
+	pods := []struct {
+		Name       string
+		Containers []struct {
+			Id string
+		}
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	for _, pod := range pods {
+		for _, ctr := range pod.Containers {
+			result[ctr.Id] = pod.Name
+		}
+	}
+	return result, nil
+}
+
+// refresh 检查连接并替换 ep.client，结束后通知等待的请求
+func (ep *Endpoint) refresh(ctx context.Context, cli *client.Client, done chan struct{}) (*client.Client, error) {
+	// 检查结果由其他请求共用，不能跟随当前请求取消
+	cli, err := ep.check(context.WithoutCancel(ctx), cli)
+	ep.lock.Lock()
+	ep.client, ep.checking = cli, nil
+	ep.lock.Unlock()
+	close(done)
+	return cli, err
+}
+
+// getClient 健康检查结果过期或正在检查时等待检查完成，避免守护进程卡住时请求使用旧连接一直阻塞
+func (ep *Endpoint) getClient(ctx context.Context) (*client.Client, error) {
+	ep.lock.Lock()
+	cli, done := ep.client, ep.checking
+	if cli != nil && done == nil && time.Since(ep.health.CheckedAt) < healthTTL {
+		ep.lock.Unlock()
+		return cli, nil
+	}
+	if done != nil {
+		ep.lock.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		ep.lock.Lock()
+		defer ep.lock.Unlock()
+		if ep.client == nil {
+			return nil, fmt.Errorf("%s: %s", ep.Name(), ep.health.Error)
+		}
+		return ep.client, nil
+	}
+	done = make(chan struct{})
+	ep.checking = done
+	ep.lock.Unlock()
+	cli, err := ep.refresh(ctx, cli, done)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ep.Name(), err)
+	}
+	return cli, nil
+}
+
+// Health 返回最近一次检查的结果，过期时触发检查
+func (ep *Endpoint) Health(ctx context.Context) Health {
+	ep.getClient(ctx)
+	ep.lock.Lock()
+	defer ep.lock.Unlock()
+	return ep.health
+}
+
+type endpointInfo struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Health
+}
+
+// HandleEndpoints 列出所有连接及其健康状态
+func (s *Server) HandleEndpoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	infos := make([]endpointInfo, len(s.endpoints))
+	wg := sync.WaitGroup{}
+	for i, ep := range s.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			infos[i] = endpointInfo{Name: ep.Name(), Host: ep.conf.Host, Health: ep.Health(r.Context())}
+		}()
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(infos)
+}
@@ -0,0 +1,361 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	NoticeStopped   = "stopped"
+	NoticeRestarted = "restarted"
+	NoticeRemoved   = "removed"
+)
+
+var listEvents = []string{"start", "die", "destroy", "rename"}
+
+// ListEvent 容器列表变化，容器被删除时 Container 只有ID和名称
+type ListEvent struct {
+	Action    string     `json:"action"`
+	Time      time.Time  `json:"time"`
+	Container *Container `json:"container"`
+}
+
+// Notice 监听日志时容器状态变化的通知，以 SSE 的 container 事件发送
+type Notice struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Previous  string    `json:"previous,omitempty"`
+	State     string    `json:"state,omitempty"`
+	ExitCode  int       `json:"exit_code"`
+	OOMKilled bool      `json:"oom_killed,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// watchItem 监听时传递的日志或者容器通知，二选一
+type watchItem struct {
+	entry  *parser.Entry
+	notice *Notice
+}
+
+func (s *Server) Handlers() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"events":    s.HandleEvents,
+		"endpoints": s.HandleEndpoints,
+		"files":     s.HandleFiles,
+		"filetail":  s.HandleFileTail,
+		"filewatch": s.HandleFileWatch,
+		"inspect":   s.HandleInspect,
+	}
+}
+
+func parseDockerTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// getContainer 获取单个容器的列表信息
+func getContainer(ctx context.Context, client *client.Client, id string) (*Container, error) {
+	summaries, err := client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("id", id)),
+	})
+	if err != nil {
+		return nil, err
+	} else if len(summaries) == 0 {
+		return nil, fmt.Errorf("container %s not found", id)
+	}
+	ctr := NewContainer(&summaries[0])
+	fillRestartCount(ctx, client, []*Container{ctr})
+	return ctr, nil
+}
+
+// watchEvents 把一个连接的容器事件转换为列表变化，连接断开时返回
+func (ep *Endpoint) watchEvents(ctx context.Context, lq *ListQuery, out chan<- *ListEvent) {
+	client, err := ep.getClient(ctx)
+	if err != nil {
+		slog.Warn("监听容器事件失败", "endpoint", ep.Name(), "err", err)
+		return
+	}
+	args := lq.Options().Filters
+	// 事件中没有容器状态，状态在收到事件后本地过滤
+	for _, state := range lq.States {
+		args.Del("status", state)
+	}
+	args.Add("type", string(events.ContainerEventType))
+	for _, action := range listEvents {
+		args.Add("event", action)
+	}
+	msgs, errs := client.Events(ctx, events.ListOptions{Filters: args})
+	for {
+		select {
+		case msg := <-msgs:
+			ev := &ListEvent{
+				Action:    string(msg.Action),
+				Time:      time.Unix(0, msg.TimeNano),
+				Container: &Container{ID: msg.Actor.ID, Name: msg.Actor.Attributes["name"]},
+			}
+			if msg.Action != events.ActionDestroy {
+				if ctr, err := getContainer(ctx, client, msg.Actor.ID); err == nil {
+					ev.Container = ctr
+				}
+			}
+			ev.Container.Endpoint = ep.Name()
+			if !lq.Match(ev.Container) || !lq.MatchState(ev.Container) {
+				continue
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		case err := <-errs:
+			if err != nil && err != io.EOF && ctx.Err() == nil {
+				slog.Debug("监听容器事件停止", "endpoint", ep.Name(), "err", err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleEvents 以 SSE 推送容器列表的变化，过滤参数与 list 相同，没有指定 endpoint 时合并所有连接的事件
+func (s *Server) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	endpoints, err := s.getEndpoints(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lq, err := ParseListQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	evs := make(chan *ListEvent)
+	wg := sync.WaitGroup{}
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ep.watchEvents(r.Context(), lq, evs)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(evs)
+	}()
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for ev := range evs {
+		fmt.Fprint(w, "data: ")
+		enc.Encode(ev)
+		fmt.Fprint(w, "\n")
+		flusher.Flush()
+	}
+}
+
+func newNotice(typ string, info *container.InspectResponse) *Notice {
+	n := &Notice{Type: typ, Time: time.Now()}
+	if info == nil || info.ContainerJSONBase == nil {
+		return n
+	}
+	n.ID = info.ID
+	if st := info.State; st != nil {
+		n.State = string(st.Status)
+		n.ExitCode = st.ExitCode
+		n.OOMKilled = st.OOMKilled
+		switch typ {
+		case NoticeStopped:
+			n.Time = parseDockerTime(st.FinishedAt)
+		case NoticeRestarted, NoticeRedeployed:
+			n.Time = parseDockerTime(st.StartedAt)
+		}
+	}
+	return n
+}
+
+// waitStart 等待容器在 since 之后启动，容器被删除时返回 false
+func waitStart(ctx context.Context, client *client.Client, id string, since time.Time) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := client.Events(ctx, events.ListOptions{
+		Since: dockerTime(since),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", id),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionDestroy)),
+		),
+	})
+	select {
+	case msg := <-msgs:
+		return msg.Action == events.ActionStart, nil
+	case err := <-errs:
+		return false, err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// stoppedAt 从历史事件中查找容器最后一次停止的时间，容器已被删除无法获取状态时使用，找不到时返回 since
+func stoppedAt(ctx context.Context, client *client.Client, id string, since time.Time) time.Time {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := client.Events(ctx, events.ListOptions{
+		Since: dockerTime(since),
+		Until: dockerTime(time.Now()),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", id),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionStop)),
+		),
+	})
+	stopped := since
+	for {
+		select {
+		case msg := <-msgs:
+			if t := time.Unix(0, msg.TimeNano); t.After(stopped) {
+				stopped = t
+			}
+		case err := <-errs:
+			if err != nil && err != io.EOF {
+				slog.Debug("获取容器停止时间失败", "id", id, "err", err)
+			}
+			return stopped
+		case <-ctx.Done():
+			return stopped
+		}
+	}
+}
+
+// waitNext 等待容器重新启动，按名称或服务跟踪时也可能是重建的新容器，返回空字符串表示容器已被删除
+func waitNext(ctx context.Context, client *client.Client, src *logSource, since time.Time, removed bool) (string, error) {
+	if src.target != nil {
+		ignore := ""
+		if removed {
+			// 已删除的容器不会再启动，它自己的启动事件可能在 since 之后
+			ignore = src.id
+		}
+		return src.target.waitRedeploy(ctx, client, since, ignore)
+	} else if removed {
+		return "", nil
+	}
+	started, err := waitStart(ctx, client, src.id, since)
+	if err != nil || !started {
+		return "", err
+	}
+	return src.id, nil
+}
+
+// followLogs 持续读取容器日志。日志流结束说明容器已停止，发送通知后等待容器重新启动并从启动时间继续读取，
+// 按名称或服务跟踪时会切换到重建后的新容器。直到容器被删除、到达 until 或者客户端断开
+func followLogs(ctx context.Context, client *client.Client, src *logSource, rd io.ReadCloser, items chan<- watchItem) {
+	defer close(items)
+	send := func(item watchItem) bool {
+		select {
+		case items <- item:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		err := src.read(ctx, rd, src.opts, func(e *parser.Entry) bool {
+			return send(watchItem{entry: e})
+		})
+		if err != nil {
+			slog.Debug("读取容器日志异常", "id", src.id, "err", err)
+		}
+		// docker 不可用时无法获取容器状态
+		if ctx.Err() != nil || len(src.opts.Until) > 0 || client == nil {
+			return
+		}
+		info, err := client.ContainerInspect(ctx, src.id)
+		removed := err != nil
+		running := !removed && info.State != nil && info.State.Running
+		if running && parseDockerTime(info.State.StartedAt).Equal(src.started) {
+			slog.Debug("容器日志流中断", "id", src.id)
+			return
+		}
+		var notice *Notice
+		if running {
+			// 日志流结束前容器已经重新启动
+			src.started = parseDockerTime(info.State.StartedAt)
+			notice = newNotice(NoticeRestarted, &info)
+		} else {
+			since := src.started
+			if removed {
+				since = stoppedAt(ctx, client, src.id, src.started)
+			} else {
+				if !send(watchItem{notice: newNotice(NoticeStopped, &info)}) {
+					return
+				}
+				if info.State != nil {
+					since = parseDockerTime(info.State.FinishedAt)
+				}
+			}
+			id, err := waitNext(ctx, client, src, since, removed)
+			if err != nil {
+				slog.Debug("等待容器启动异常", "id", src.id, "err", err)
+				return
+			} else if len(id) == 0 {
+				send(watchItem{notice: &Notice{Type: NoticeRemoved, ID: src.id, Time: time.Now()}})
+				return
+			}
+			previous := src.id
+			next, err := src.attach(ctx, client, id)
+			if err != nil {
+				slog.Debug("获取容器信息失败", "id", id, "err", err)
+				return
+			}
+			if src.id == previous {
+				notice = newNotice(NoticeRestarted, next)
+			} else {
+				notice = newNotice(NoticeRedeployed, next)
+				notice.Previous = previous
+			}
+		}
+		if !send(watchItem{notice: notice}) {
+			return
+		}
+		src.opts.Since = dockerTime(src.started)
+		src.opts.Tail = ""
+		if rd, err = src.open(ctx, client, src.opts); err != nil {
+			slog.Debug("读取容器日志异常", "id", src.id, "err", err)
+			return
+		}
+	}
+}
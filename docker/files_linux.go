@@ -0,0 +1,544 @@
+//go:build linux
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/dirfiles"
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+)
+
+// 与 dirfiles 相同，没有 tail 参数时读取最后1000行
+const defaultFileTail = 1000
+
+var (
+	ErrPathNotAllowed = errors.New("path not allowed")
+	ErrNotRegularFile = errors.New("not a regular file")
+)
+
+// ContainerFile 容器内配置目录下的文件
+type ContainerFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// fileSource 读取容器内文件所需的信息
+type fileSource struct {
+	*logSource
+	client *client.Client
+	dirs   []string
+}
+
+// getFileSource 允许访问的目录为配置文件中的 files 加上容器标签 log-viewer.files
+func (s *Server) getFileSource(ctx context.Context, q url.Values) (*fileSource, error) {
+	ep, client, err := s.getClient(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.getLogSource(ctx, ep, client, q)
+	if err != nil {
+		return nil, err
+	}
+	fs := &fileSource{logSource: src, client: client}
+	for _, dir := range append(strings.Split(src.labels[FilesLabel], ","), s.files...) {
+		if dir = strings.TrimSpace(dir); path.IsAbs(dir) {
+			fs.dirs = append(fs.dirs, path.Clean(dir))
+		}
+	}
+	return fs, nil
+}
+
+// allowed 检查路径是否在允许访问的目录下
+func (fs *fileSource) allowed(p string) (string, error) {
+	p = path.Clean(p)
+	for _, dir := range fs.dirs {
+		if strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return p, nil
+		}
+	}
+	return "", errors.Wrap(ErrPathNotAllowed, p)
+}
+
+// resolve 检查路径及其解析符号链接后的真实路径都在允许访问的目录下。
+// 容器中没有 readlink 时通过文件信息接口逐级检查，路径中有符号链接时拒绝访问
+func (fs *fileSource) resolve(ctx context.Context, p string) (string, error) {
+	p, err := fs.allowed(p)
+	if err != nil {
+		return "", err
+	}
+	out, err := fs.execOutput(ctx, "readlink", "-f", "--", p)
+	if err == nil {
+		return fs.allowed(strings.TrimSpace(string(out)))
+	}
+	slog.Debug("在容器中解析路径失败，改为逐级检查", "id", fs.id, "path", p, "err", err)
+	for _, dir := range fs.dirs {
+		rel, ok := strings.CutPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+		if !ok {
+			continue
+		}
+		cur := dir
+		for _, name := range strings.Split(rel, "/") {
+			cur = path.Join(cur, name)
+			stat, err := fs.client.ContainerStatPath(ctx, fs.id, cur)
+			if err != nil {
+				return "", err
+			} else if stat.Mode&os.ModeSymlink != 0 {
+				return "", errors.Wrap(ErrPathNotAllowed, cur)
+			}
+		}
+		return p, nil
+	}
+	return "", errors.Wrap(ErrPathNotAllowed, p)
+}
+
+// exec 在容器中执行命令，返回多路复用的输出流
+func (fs *fileSource) exec(ctx context.Context, cmd ...string) (string, io.ReadCloser, error) {
+	created, err := fs.client.ContainerExecCreate(ctx, fs.id, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := fs.client.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	return created.ID, struct {
+		io.Reader
+		io.Closer
+	}{resp.Reader, resp.Conn}, nil
+}
+
+// execOutput 在容器中执行命令并读取全部输出，退出码不为0时返回错误输出
+func (fs *fileSource) execOutput(ctx context.Context, cmd ...string) ([]byte, error) {
+	execID, rd, err := fs.exec(ctx, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if _, err = stdcopy.StdCopy(stdout, stderr, rd); err != nil {
+		return nil, err
+	}
+	info, err := fs.client.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return nil, err
+	} else if info.ExitCode != 0 {
+		return nil, fmt.Errorf("exit code %d: %s", info.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// execListFiles 使用容器内的 find 和 stat 列出文件，容器中没有这些命令时失败
+func (fs *fileSource) execListFiles(ctx context.Context) ([]*ContainerFile, error) {
+	cmd := append([]string{"find"}, fs.dirs...)
+	execID, rd, err := fs.exec(ctx, append(cmd, "-type", "f", "-exec", "stat", "-c", "%s %Y %n", "{}", "+")...)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if _, err = stdcopy.StdCopy(stdout, stderr, rd); err != nil {
+		return nil, err
+	}
+	// 部分目录不存在时 find 也会返回非0
+	if info, err := fs.client.ContainerExecInspect(ctx, execID); err == nil && info.ExitCode != 0 && stdout.Len() == 0 {
+		return nil, fmt.Errorf("exit code %d: %s", info.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	files := []*ContainerFile{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		mtime, _ := strconv.ParseInt(fields[1], 10, 64)
+		files = append(files, &ContainerFile{Path: fields[2], Size: size, ModTime: time.Unix(mtime, 0)})
+	}
+	return files, nil
+}
+
+// archiveListFiles 通过归档接口读取 tar 头列出文件，需要传输整个目录的内容
+func (fs *fileSource) archiveListFiles(ctx context.Context) ([]*ContainerFile, error) {
+	files := []*ContainerFile{}
+	for _, dir := range fs.dirs {
+		rd, _, err := fs.client.CopyFromContainer(ctx, fs.id, dir)
+		if err != nil {
+			slog.Debug("读取容器目录失败", "id", fs.id, "dir", dir, "err", err)
+			continue
+		}
+		tr := tar.NewReader(rd)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			} else if hdr.Typeflag == tar.TypeReg {
+				// tar 中的路径以目录名开头
+				files = append(files, &ContainerFile{
+					Path:    path.Join(path.Dir(dir), hdr.Name),
+					Size:    hdr.Size,
+					ModTime: hdr.ModTime,
+				})
+			}
+		}
+		rd.Close()
+	}
+	return files, nil
+}
+
+// openFile 通过归档接口读取文件，返回文件内容和大小。符号链接在归档中不会被解析，不是普通文件时拒绝
+func (fs *fileSource) openFile(ctx context.Context, p string) (io.ReadCloser, int64, error) {
+	rd, _, err := fs.client.CopyFromContainer(ctx, fs.id, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	tr := tar.NewReader(rd)
+	hdr, err := tr.Next()
+	if err != nil {
+		rd.Close()
+		return nil, 0, err
+	} else if hdr.Typeflag != tar.TypeReg {
+		rd.Close()
+		return nil, 0, errors.Wrap(ErrNotRegularFile, p)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, rd}, hdr.Size, nil
+}
+
+// fileEncoding 请求参数 encoding 优先，否则根据文件开头检测
+func fileEncoding(q url.Values, br *bufio.Reader) (*dirfiles.TextEncoding, error) {
+	enc, err := dirfiles.GetEncoding(q.Get("encoding"))
+	if err != nil || enc != nil {
+		return enc, err
+	}
+	head, _ := br.Peek(br.Size())
+	return dirfiles.DetectEncoding(bytes.NewReader(head)), nil
+}
+
+// tailFile 读取文件最后 tail 个事件，tail 小于等于0时读取全部，返回文件大小。
+// 优先在容器中执行 tail 只传输最后的部分，容器中没有 shell 时通过归档接口读取
+func (fs *fileSource) tailFile(ctx context.Context, q url.Values, p string, fn func(*parser.Entry) bool) (int64, *dirfiles.TextEncoding, error) {
+	var tail int64 = defaultFileTail
+	if q.Has("tail") {
+		var err error
+		if tail, err = strconv.ParseInt(q.Get("tail"), 10, 64); err != nil {
+			return 0, nil, errors.Wrap(ErrInvalidQuery, "tail")
+		}
+	}
+	if tail > 0 {
+		entries, size, enc, err := fs.execTailFile(ctx, q, p, tail)
+		if err == nil {
+			for _, e := range entries {
+				if !fn(e) {
+					break
+				}
+			}
+			return size, enc, nil
+		}
+		slog.Debug("在容器中读取文件失败，改为读取归档", "id", fs.id, "path", p, "err", err)
+	}
+	return fs.archiveTailFile(ctx, q, p, tail, fn)
+}
+
+// execTailFile 先记录文件大小，只输出该大小以内的最后几行，之后的内容由 watch 从该位置继续读取。
+// 多行合并时 tail 按事件计数，逐步加大行数直到包含足够的完整事件
+func (fs *fileSource) execTailFile(ctx context.Context, q url.Values, p string, tail int64) ([]*parser.Entry, int64, *dirfiles.TextEncoding, error) {
+	physical := tail
+	if fs.ml != nil {
+		physical = tail + 1
+	}
+	for {
+		out, err := fs.execOutput(ctx, "sh", "-c",
+			`size=$(stat -c %s -- "$1") && echo "$size" && head -c "$size" -- "$1" | tail -n "$2"`,
+			"sh", p, strconv.FormatInt(physical, 10))
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		first, content, _ := bytes.Cut(out, []byte{'\n'})
+		size, err := strconv.ParseInt(string(first), 10, 64)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("unexpected output: %q", first)
+		}
+		br := bufio.NewReaderSize(bytes.NewReader(content), server.GlobalBufSize)
+		enc, err := fileEncoding(q, br)
+		if err != nil {
+			return nil, 0, nil, err
+		} else if enc.Unit != 1 {
+			// tail 按单字节换行符切分，UTF-16 的内容可能没有对齐
+			return nil, 0, nil, errors.Wrap(dirfiles.ErrUnsupportEncoding, enc.Name)
+		}
+		lp, err := dirfiles.NewLineParserFactory(dirfiles.FormatRaw, fs.ml)()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		entries := []*parser.Entry{}
+		if err = dirfiles.ReadEntries(enc.NewReader(br), lp, func(e *parser.Entry) bool {
+			entries = append(entries, e)
+			return true
+		}); err != nil {
+			return nil, 0, nil, err
+		}
+		lines := int64(bytes.Count(content, []byte{'\n'}))
+		if len(content) > 0 && !bytes.HasSuffix(content, []byte{'\n'}) {
+			lines++
+		}
+		// 第一个事件可能不完整，多读到一个事件或者已经读完整个文件时结束
+		if fs.ml == nil || int64(len(entries)) > tail || lines < physical {
+			return entries[max(int64(len(entries))-tail, 0):], size, enc, nil
+		}
+		physical *= 2
+	}
+}
+
+// archiveTailFile 通过归档接口读取，需要传输整个文件
+func (fs *fileSource) archiveTailFile(ctx context.Context, q url.Values, p string, tail int64, fn func(*parser.Entry) bool) (int64, *dirfiles.TextEncoding, error) {
+	rd, size, err := fs.openFile(ctx, p)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rd.Close()
+	br := bufio.NewReaderSize(rd, server.GlobalBufSize)
+	enc, err := fileEncoding(q, br)
+	if err != nil {
+		return 0, nil, err
+	}
+	lp, err := dirfiles.NewLineParserFactory(dirfiles.FormatRaw, fs.ml)()
+	if err != nil {
+		return 0, nil, err
+	}
+	// 归档接口只能从头读取，保留最后 tail 个事件
+	buffer := []*parser.Entry{}
+	err = dirfiles.ReadEntries(enc.NewReader(br), lp, func(e *parser.Entry) bool {
+		if tail <= 0 {
+			return fn(e)
+		}
+		if buffer = append(buffer, e); int64(len(buffer)) > tail {
+			buffer = buffer[1:]
+		}
+		return true
+	})
+	for _, e := range buffer {
+		if !fn(e) {
+			break
+		}
+	}
+	return size, enc, err
+}
+
+func (s *Server) HandleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	fs, err := s.getFileSource(r.Context(), r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files := []*ContainerFile{}
+	if len(fs.dirs) > 0 {
+		if files, err = fs.execListFiles(r.Context()); err != nil {
+			slog.Debug("在容器中列出文件失败，改为读取归档", "id", fs.id, "err", err)
+			if files, err = fs.archiveListFiles(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(files)
+}
+
+// HandleFileTail 参数与 dirfiles 的 tail 相同，文件由 id(或 name、service) 和 path 指定
+func (s *Server) HandleFileTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	if err := server.EnsureKeys(q, "path"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs, err := s.getFileSource(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := fs.resolve(r.Context(), q.Get("path"))
+	if errors.Is(err, ErrPathNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", ew.ContentType())
+	if _, _, err = fs.tailFile(r.Context(), q, p, func(e *parser.Entry) bool {
+		return !fs.pipeline.Apply(e) || ew.Write(e) == nil
+	}); err != nil {
+		slog.Debug("读取容器文件异常", "id", fs.id, "path", p, "err", err)
+	}
+}
+
+// HandleFileWatch 先读取最后的日志，再在容器中执行 tail -F 监听，容器中没有 shell 或 tail 命令时无法监听。
+// tail 进程由 shell 启动并输出进程号，连接断开后结束该进程
+func (s *Server) HandleFileWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := server.EnsureKeys(q, "path"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fs, err := s.getFileSource(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := fs.resolve(r.Context(), q.Get("path"))
+	if errors.Is(err, ErrPathNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lp, err := dirfiles.NewLineParser(dirfiles.FormatRaw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries := []*parser.Entry{}
+	size, enc, err := fs.tailFile(r.Context(), q, p, func(e *parser.Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, rd, err := fs.exec(r.Context(), "sh", "-c", `echo $$ && exec tail -c "+$2" -F -- "$1"`,
+		"sh", p, strconv.FormatInt(size+1, 10))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rd.Close()
+	pr, pw := io.Pipe()
+	stderr := &bytes.Buffer{}
+	go func() {
+		_, err := stdcopy.StdCopy(pw, stderr, rd)
+		pw.CloseWithError(err)
+	}()
+	br := bufio.NewReader(pr)
+	pid, err := br.ReadString('\n')
+	if err != nil {
+		// 输出已经结束，stderr 不会再写入
+		http.Error(w, fmt.Sprintf("tail: %s", strings.TrimSpace(stderr.String())), http.StatusInternalServerError)
+		return
+	} else if pid = strings.TrimSpace(pid); len(pid) == 0 || strings.Trim(pid, "0123456789") != "" {
+		http.Error(w, fmt.Sprintf("unexpected output: %q", pid), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		// 请求的 ctx 已经结束，单独设置超时
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), pingTimeout)
+		defer cancel()
+		if _, err := fs.execOutput(ctx, "sh", "-c", `kill "$1"`, "sh", pid); err != nil {
+			slog.Debug("结束容器中的 tail 进程失败", "id", fs.id, "pid", pid, "err", err)
+		}
+	}()
+
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	write := func(entries ...*parser.Entry) {
+		for _, e := range entries {
+			if e != nil && fs.pipeline.Apply(e) {
+				ew.WriteEvent("", e)
+			}
+		}
+		flusher.Flush()
+	}
+	write(entries...)
+
+	lines := make(chan *parser.Entry)
+	go func() {
+		defer close(lines)
+		dirfiles.ReadEntries(enc.NewReader(br), lp, func(e *parser.Entry) bool {
+			select {
+			case lines <- e:
+				return true
+			case <-r.Context().Done():
+				return false
+			}
+		})
+	}()
+	g := fs.ml.NewGrouper()
+	flushTicker := time.NewTicker(time.Hour)
+	if g != nil && g.Timeout() > 0 {
+		flushTicker.Reset(g.Timeout() / 2)
+	} else {
+		flushTicker.Stop()
+	}
+	defer flushTicker.Stop()
+	for {
+		select {
+		case e, ok := <-lines:
+			if !ok {
+				write(g.Flush()...)
+				return
+			}
+			write(g.Add(e))
+		case <-flushTicker.C:
+			write(g.FlushStale()...)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
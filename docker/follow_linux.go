@@ -0,0 +1,140 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+const NoticeRedeployed = "redeployed"
+
+var ErrContainerNotFound = errors.New("container not found")
+
+// followTarget 按名称或 compose 服务查找容器，容器重建后ID会变化，但名称和服务不变
+type followTarget struct {
+	Name    string
+	Project string
+	Service string
+}
+
+// parseTarget 请求中没有 id 时使用 name 或 service(可选 project) 查找容器
+func parseTarget(q url.Values) (*followTarget, error) {
+	if q.Has("id") {
+		return nil, nil
+	}
+	t := &followTarget{
+		Name:    strings.TrimPrefix(q.Get("name"), "/"),
+		Project: q.Get("project"),
+		Service: q.Get("service"),
+	}
+	if len(t.Name) == 0 && len(t.Service) == 0 {
+		return nil, server.EnsureKeys(q, "id")
+	}
+	return t, nil
+}
+
+func (t *followTarget) String() string {
+	if len(t.Name) > 0 {
+		return t.Name
+	}
+	return t.Project + "/" + t.Service
+}
+
+func (t *followTarget) filters() filters.Args {
+	args := filters.NewArgs()
+	if len(t.Name) > 0 {
+		args.Add("name", t.Name)
+	}
+	if len(t.Service) > 0 {
+		args.Add("label", ComposeServiceLabel+"="+t.Service)
+	}
+	if len(t.Project) > 0 {
+		args.Add("label", ComposeProjectLabel+"="+t.Project)
+	}
+	return args
+}
+
+// match docker 的 name 过滤是部分匹配，这里再精确比较一次
+func (t *followTarget) match(names []string) bool {
+	return len(t.Name) == 0 || slices.Contains(names, "/"+t.Name) || slices.Contains(names, t.Name)
+}
+
+// resolve 查找匹配的容器，优先返回运行中的，其次是最新创建的
+func (t *followTarget) resolve(ctx context.Context, client *client.Client) (string, error) {
+	summaries, err := client.ContainerList(ctx, container.ListOptions{All: true, Filters: t.filters()})
+	if err != nil {
+		return "", err
+	}
+	running := func(ctr *container.Summary) bool { return ctr.State == "running" }
+	var found *container.Summary
+	for i := range summaries {
+		ctr := &summaries[i]
+		if !t.match(ctr.Names) {
+			continue
+		}
+		if found == nil || running(ctr) && !running(found) ||
+			running(ctr) == running(found) && ctr.Created > found.Created {
+			found = ctr
+		}
+	}
+	if found == nil {
+		return "", errors.Wrap(ErrContainerNotFound, t.String())
+	}
+	return found.ID, nil
+}
+
+// waitRedeploy 等待 since 之后启动的同名容器，返回容器的ID，可能是原容器重新启动。忽略ID为 ignore 的容器
+func (t *followTarget) waitRedeploy(ctx context.Context, client *client.Client, since time.Time, ignore string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	args := t.filters()
+	// 事件的 name 过滤条件叫 container
+	if len(t.Name) > 0 {
+		args.Del("name", t.Name)
+		args.Add("container", t.Name)
+	}
+	args.Add("type", string(events.ContainerEventType))
+	args.Add("event", string(events.ActionStart))
+	msgs, errs := client.Events(ctx, events.ListOptions{Since: dockerTime(since), Filters: args})
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.TimeNano > since.UnixNano() && msg.Actor.ID != ignore && t.match([]string{msg.Actor.Attributes["name"]}) {
+				return msg.Actor.ID, nil
+			}
+		case err := <-errs:
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// attach 切换到指定容器，更新TTY和启动时间
+func (src *logSource) attach(ctx context.Context, client *client.Client, id string) (*container.InspectResponse, error) {
+	info, err := client.ContainerInspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	src.id = info.ID
+	if info.ContainerJSONBase != nil && info.State != nil {
+		src.started = parseDockerTime(info.State.StartedAt)
+	}
+	if info.Config != nil {
+		src.tty = info.Config.Tty
+	}
+	src.journal = src.local && info.ContainerJSONBase != nil && info.HostConfig != nil &&
+		info.HostConfig.LogConfig.Type == "journald"
+	return &info, nil
+}
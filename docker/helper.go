@@ -1,6 +1,48 @@
 package docker
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 容器标签，指定该容器日志使用的解析器
+	ParserLabel = "log-viewer.parser"
+	// 容器标签，指定该容器日志的多行合并规则
+	MultilineStartLabel    = "log-viewer.multiline.start"
+	MultilineContinueLabel = "log-viewer.multiline.continue"
+	// 容器标签，允许读取的容器内日志目录，多个用逗号分隔
+	FilesLabel = "log-viewer.files"
+	// docker compose 写入的容器标签
+	ComposeProjectLabel = "com.docker.compose.project"
+	ComposeServiceLabel = "com.docker.compose.service"
+
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
 var (
-	Enabled      bool
-	AllContainer bool
+	Enabled        bool
+	ConfigFilePath string
+	AllContainer   bool
+	DefaultParser  string
+	DataRoot       string
 )
+
+// dockerTime 转换为docker接口使用的 秒.纳秒 格式
+func dockerTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// parseDockerTimestamp 解析 dockerTime 的结果，空字符串返回零值
+func parseDockerTimestamp(s string) time.Time {
+	sec, nsec, _ := strings.Cut(s, ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	nsecs, _ := strconv.ParseInt(nsec, 10, 64)
+	return time.Unix(secs, nsecs)
+}
@@ -0,0 +1,173 @@
+//go:build linux
+
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/docker/docker/api/types/container"
+)
+
+var (
+	// 名称匹配时隐藏环境变量和标签的值
+	secretEnvPattern = regexp.MustCompile(`(?i)pass|secret|token|key|credential|auth|private|cert`)
+	// 值中 URL 的用户信息，如 postgres://user:pw@db、redis://:pw@cache
+	urlUserinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)([^/?#@\s]*)@`)
+)
+
+const maskedValue = "******"
+
+type HealthLog struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+}
+
+type HealthState struct {
+	Status        string       `json:"status"`
+	FailingStreak int          `json:"failing_streak"`
+	Log           []*HealthLog `json:"log"`
+}
+
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	RW          bool   `json:"rw"`
+}
+
+// Inspect 精简后的容器详情，用于解释日志中断的原因
+type Inspect struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Endpoint      string            `json:"endpoint"`
+	Image         string            `json:"image"`
+	Created       time.Time         `json:"created"`
+	State         string            `json:"state"`
+	ExitCode      int               `json:"exit_code"`
+	OOMKilled     bool              `json:"oom_killed"`
+	Error         string            `json:"error,omitempty"`
+	StartedAt     time.Time         `json:"started_at"`
+	FinishedAt    time.Time         `json:"finished_at"`
+	RestartCount  int               `json:"restart_count"`
+	RestartPolicy string            `json:"restart_policy,omitempty"`
+	Health        *HealthState      `json:"health,omitempty"`
+	Mounts        []*Mount          `json:"mounts"`
+	Env           []string          `json:"env"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// maskValue 名称像密码、令牌等时隐藏整个值，否则隐藏值中 URL 的密码，只有用户名部分时也可能是令牌，整体隐藏
+func maskValue(name, value string) string {
+	if secretEnvPattern.MatchString(name) {
+		return maskedValue
+	}
+	return urlUserinfoPattern.ReplaceAllStringFunc(value, func(match string) string {
+		groups := urlUserinfoPattern.FindStringSubmatch(match)
+		if user, _, ok := strings.Cut(groups[2], ":"); ok {
+			return groups[1] + user + ":" + maskedValue + "@"
+		}
+		return groups[1] + maskedValue + "@"
+	})
+}
+
+// maskEnv 隐藏环境变量中的敏感信息
+func maskEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			kv = k + "=" + maskValue(k, v)
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+// maskLabels 与环境变量使用相同的规则
+func maskLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = maskValue(k, v)
+	}
+	return result
+}
+
+func NewInspect(info *container.InspectResponse) *Inspect {
+	ins := &Inspect{Mounts: []*Mount{}, Env: []string{}}
+	if base := info.ContainerJSONBase; base != nil {
+		ins.ID = base.ID
+		ins.Name = strings.TrimPrefix(base.Name, "/")
+		ins.Created = parseDockerTime(base.Created)
+		ins.RestartCount = base.RestartCount
+		if base.HostConfig != nil {
+			ins.RestartPolicy = string(base.HostConfig.RestartPolicy.Name)
+		}
+		if state := base.State; state != nil {
+			ins.State = string(state.Status)
+			ins.ExitCode = state.ExitCode
+			ins.OOMKilled = state.OOMKilled
+			ins.Error = state.Error
+			ins.StartedAt = parseDockerTime(state.StartedAt)
+			ins.FinishedAt = parseDockerTime(state.FinishedAt)
+			if state.Health != nil {
+				ins.Health = &HealthState{Status: string(state.Health.Status), FailingStreak: state.Health.FailingStreak, Log: []*HealthLog{}}
+				for _, l := range state.Health.Log {
+					ins.Health.Log = append(ins.Health.Log, &HealthLog{Start: l.Start, End: l.End, ExitCode: l.ExitCode, Output: l.Output})
+				}
+			}
+		}
+	}
+	if info.Config != nil {
+		ins.Image = info.Config.Image
+		ins.Env = maskEnv(info.Config.Env)
+		ins.Labels = maskLabels(info.Config.Labels)
+	}
+	for _, m := range info.Mounts {
+		ins.Mounts = append(ins.Mounts, &Mount{Type: string(m.Type), Source: m.Source, Destination: m.Destination, RW: m.RW})
+	}
+	return ins
+}
+
+// HandleInspect 容器由 id 或 name、service 指定
+func (s *Server) HandleInspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	ep, client, err := s.getClient(r.Context(), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := q.Get("id")
+	if target, err := parseTarget(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if target != nil {
+		if id, err = target.resolve(r.Context(), client); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	info, err := client.ContainerInspect(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	ins := NewInspect(&info)
+	ins.Endpoint = ep.Name()
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(ins)
+}
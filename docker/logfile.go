@@ -0,0 +1,395 @@
+package docker
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	LogDriverJSONFile = "json-file"
+	LogDriverLocal    = "local"
+
+	// local 日志驱动单条记录的上限，超过说明文件已损坏
+	maxLocalEntrySize = 1 << 20
+	logFilePollPeriod = 200 * time.Millisecond
+)
+
+var ErrUnsupportDriver = errors.New("unsupported log driver")
+
+// logDecoder 从日志文件中逐条读取日志，被拆分的长行已合并
+type logDecoder func(rd io.Reader, fn func(*parser.Entry) bool) error
+
+// partials 按输出流合并 docker 拆分的长行
+type partials map[string]*strings.Builder
+
+func (p partials) add(stream string, ts time.Time, text string, partial bool) *parser.Entry {
+	if partial {
+		if p[stream] == nil {
+			p[stream] = &strings.Builder{}
+		}
+		p[stream].WriteString(text)
+		return nil
+	}
+	if b := p[stream]; b != nil {
+		b.WriteString(text)
+		text = b.String()
+		delete(p, stream)
+	}
+	return &parser.Entry{TimeStamp: float64(ts.UnixNano()) / 1e6, Stream: stream, Message: text}
+}
+
+// decodeJSONFile 解析 json-file 驱动的日志，每行为 {"log":"...\n","stream":"stdout","time":"..."}，
+// log 不以换行结尾的是被拆分的长行
+func decodeJSONFile(rd io.Reader, fn func(*parser.Entry) bool) error {
+	br := bufio.NewReader(rd)
+	pending := partials{}
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec struct {
+				Log    string    `json:"log"`
+				Stream string    `json:"stream"`
+				Time   time.Time `json:"time"`
+			}
+			if json.Unmarshal(line, &rec) == nil {
+				text, complete := strings.CutSuffix(rec.Log, "\n")
+				if e := pending.add(rec.Stream, rec.Time, text, !complete); e != nil && !fn(e) {
+					return nil
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeLocal 解析 local 驱动的日志，每条记录为 4字节长度 + protobuf LogEntry + 4字节长度
+func decodeLocal(rd io.Reader, fn func(*parser.Entry) bool) error {
+	br := bufio.NewReader(rd)
+	size := make([]byte, 4)
+	buf := []byte{}
+	pending := partials{}
+	for {
+		if _, err := io.ReadFull(br, size); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > maxLocalEntrySize {
+			return errors.Errorf("local log entry too large: %d", n)
+		}
+		if cap(buf) < int(n)+4 {
+			buf = make([]byte, n+4)
+		}
+		buf = buf[:n+4]
+		if _, err := io.ReadFull(br, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		rec, err := unmarshalLogEntry(buf[:n])
+		if err != nil {
+			return err
+		}
+		if e := pending.add(rec.source, time.Unix(0, rec.timeNano), string(rec.line), rec.partial); e != nil && !fn(e) {
+			return nil
+		}
+	}
+}
+
+type localLogEntry struct {
+	source   string
+	timeNano int64
+	line     []byte
+	partial  bool
+}
+
+// unmarshalLogEntry 解析 moby 的 LogEntry 消息，只取需要的字段
+//
+//	1: source string, 2: time_nano int64, 3: line bytes, 4: partial bool
+func unmarshalLogEntry(b []byte) (*localLogEntry, error) {
+	rec := &localLogEntry{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("bad protobuf key")
+		}
+		b = b[n:]
+		field, wire := key>>3, key&7
+		switch wire {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("bad protobuf varint")
+			}
+			b = b[n:]
+			switch field {
+			case 2:
+				rec.timeNano = int64(v)
+			case 4:
+				rec.partial = v != 0
+			}
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errors.New("bad protobuf length")
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			switch field {
+			case 1:
+				rec.source = string(v)
+			case 3:
+				rec.line = v
+			}
+		case 1:
+			if len(b) < 8 {
+				return nil, errors.New("bad protobuf fixed64")
+			}
+			b = b[8:]
+		case 5:
+			if len(b) < 4 {
+				return nil, errors.New("bad protobuf fixed32")
+			}
+			b = b[4:]
+		default:
+			return nil, errors.Errorf("unsupported protobuf wire type %d", wire)
+		}
+	}
+	return rec, nil
+}
+
+// rotatedFiles 返回 path 及其轮转文件，从旧到新排列，轮转文件可能被gzip压缩
+func rotatedFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+	type rotated struct {
+		path  string
+		index int
+	}
+	files := []rotated{}
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		if idx, err := strconv.Atoi(suffix); err == nil {
+			files = append(files, rotated{m, idx})
+		}
+	}
+	slices.SortFunc(files, func(a, b rotated) int { return b.index - a.index })
+	result := []string{}
+	for _, f := range files {
+		result = append(result, f.path)
+	}
+	return append(result, path)
+}
+
+func openLogFile(path string) (io.ReadCloser, error) {
+	fd, err := os.Open(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return fd, err
+	}
+	gz, err := gzip.NewReader(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, fd}, nil
+}
+
+// followFile 读到文件末尾时等待新内容，文件被轮转或截断时重新打开，ctx 结束时返回 EOF。
+// 第一次读到末尾时调用 onIdle
+type followFile struct {
+	ctx    context.Context
+	path   string
+	fd     *os.File
+	offset int64
+	onIdle func()
+}
+
+func (f *followFile) Read(b []byte) (int, error) {
+	for {
+		n, err := f.fd.Read(b)
+		f.offset += int64(n)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		if f.onIdle != nil {
+			f.onIdle()
+			f.onIdle = nil
+		}
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(logFilePollPeriod):
+		}
+		if err := f.checkRotate(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (f *followFile) checkRotate() error {
+	stat, err := os.Stat(f.path)
+	if err != nil {
+		// 轮转过程中文件可能暂时不存在
+		return nil
+	}
+	cur, err := f.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(stat, cur) {
+		fd, err := os.Open(f.path)
+		if err != nil {
+			return nil
+		}
+		f.fd.Close()
+		f.fd, f.offset = fd, 0
+	} else if stat.Size() < f.offset {
+		f.offset, err = f.fd.Seek(0, io.SeekStart)
+	}
+	return err
+}
+
+func (f *followFile) Close() error {
+	return f.fd.Close()
+}
+
+// LogFileOptions 直接读取日志文件时的选项，与 container.LogsOptions 对应
+type LogFileOptions struct {
+	// 小于 0 时读取全部，为 0 时不读取历史日志
+	Tail   int64
+	Since  time.Time
+	Until  time.Time
+	Follow bool
+	Stdout bool
+	Stderr bool
+}
+
+// ReadLogFiles 读取 json-file 或 local 驱动的日志文件
+func ReadLogFiles(ctx context.Context, driver, path string, opts LogFileOptions, fn func(*parser.Entry) bool) error {
+	var decode logDecoder
+	switch driver {
+	case LogDriverJSONFile:
+		decode = decodeJSONFile
+	case LogDriverLocal:
+		decode = decodeLocal
+	default:
+		return errors.Wrap(ErrUnsupportDriver, driver)
+	}
+	buffer := []*parser.Entry{}
+	// stopped 客户端不再接收，reached 已经到达 Until
+	stopped, reached := false, false
+	emit := func(e *parser.Entry) bool {
+		if stopped = !fn(e); stopped {
+			return false
+		}
+		return true
+	}
+	// tail 时先缓存最后的日志，读完历史日志再输出
+	flush := func() {
+		for _, e := range buffer {
+			if !emit(e) {
+				break
+			}
+		}
+		buffer = nil
+	}
+	collect := func(e *parser.Entry) bool {
+		ts := time.UnixMilli(int64(e.TimeStamp))
+		switch {
+		case stopped || ctx.Err() != nil:
+			return false
+		case e.Stream == StreamStdout && !opts.Stdout, e.Stream == StreamStderr && !opts.Stderr:
+			return true
+		case !opts.Since.IsZero() && ts.Before(opts.Since):
+			return true
+		case !opts.Until.IsZero() && ts.After(opts.Until):
+			reached = true
+			return false
+		case opts.Tail > 0 && buffer != nil:
+			if buffer = append(buffer, e); int64(len(buffer)) > opts.Tail {
+				buffer = buffer[1:]
+			}
+			return true
+		}
+		return emit(e)
+	}
+	if opts.Tail < 0 {
+		buffer = nil
+	} else if opts.Tail == 0 {
+		return followLogFile(ctx, path, opts, decode, collect)
+	}
+	files := rotatedFiles(path)
+	for _, name := range files[:len(files)-1] {
+		if stopped || reached || ctx.Err() != nil {
+			break
+		}
+		rd, err := openLogFile(name)
+		if err != nil {
+			continue
+		}
+		err = decode(rd, collect)
+		rd.Close()
+		if err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	if stopped || reached || ctx.Err() != nil {
+		flush()
+		return nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	var rd io.ReadCloser = fd
+	if opts.Follow {
+		rd = &followFile{ctx: ctx, path: path, fd: fd, onIdle: flush}
+	}
+	defer rd.Close()
+	if err = decode(rd, collect); err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
+
+// followLogFile 不读取历史日志，从当前文件末尾开始等待新的日志
+func followLogFile(ctx context.Context, path string, opts LogFileOptions, decode logDecoder, fn func(*parser.Entry) bool) error {
+	if !opts.Follow {
+		return nil
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	offset, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	rd := &followFile{ctx: ctx, path: path, fd: fd, offset: offset}
+	defer rd.Close()
+	return decode(rd, fn)
+}
@@ -0,0 +1,226 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// offlineContainer 数据目录中 config.v2.json 的部分内容
+type offlineContainer struct {
+	ID           string
+	Name         string
+	Created      time.Time
+	RestartCount int
+	LogPath      string
+	Config       struct {
+		Image  string
+		Labels map[string]string
+		Tty    bool
+	}
+	State struct {
+		Running    bool
+		Paused     bool
+		Restarting bool
+		Dead       bool
+		StartedAt  time.Time
+		FinishedAt time.Time
+	}
+	// hostconfig.json 中的日志驱动
+	driver string
+	dir    string
+}
+
+func (ctr *offlineContainer) state() string {
+	switch {
+	case ctr.State.Restarting:
+		return "restarting"
+	case ctr.State.Paused:
+		return "paused"
+	case ctr.State.Running:
+		return "running"
+	case ctr.State.Dead:
+		return "dead"
+	case ctr.State.StartedAt.IsZero() || ctr.State.StartedAt.Year() <= 1:
+		return "created"
+	}
+	return "exited"
+}
+
+// logPath json-file 驱动的路径记录在配置中，local 驱动的在容器目录的 local-logs 下
+func (ctr *offlineContainer) logPath() string {
+	if ctr.driver == LogDriverLocal {
+		return filepath.Join(ctr.dir, "local-logs", "container.log")
+	}
+	return ctr.LogPath
+}
+
+func (ctr *offlineContainer) toContainer(endpoint string) *Container {
+	return &Container{
+		ID:           ctr.ID,
+		Name:         strings.TrimPrefix(ctr.Name, "/"),
+		Endpoint:     endpoint,
+		Image:        ctr.Config.Image,
+		State:        ctr.state(),
+		Status:       "offline",
+		Created:      ctr.Created,
+		RestartCount: ctr.RestartCount,
+		Project:      ctr.Config.Labels[ComposeProjectLabel],
+		Service:      ctr.Config.Labels[ComposeServiceLabel],
+		Labels:       ctr.Config.Labels,
+	}
+}
+
+func readOfflineContainer(dir string) (*offlineContainer, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "config.v2.json"))
+	if err != nil {
+		return nil, err
+	}
+	ctr := &offlineContainer{dir: dir, driver: LogDriverJSONFile}
+	if err = json.Unmarshal(data, ctr); err != nil {
+		return nil, err
+	}
+	if data, err = os.ReadFile(filepath.Join(dir, "hostconfig.json")); err == nil {
+		hc := struct{ LogConfig struct{ Type string } }{}
+		if json.Unmarshal(data, &hc) == nil && len(hc.LogConfig.Type) > 0 {
+			ctr.driver = hc.LogConfig.Type
+		}
+	}
+	return ctr, nil
+}
+
+// offlineContainers 读取数据目录中的所有容器配置，跳过无法解析的目录
+func (ep *Endpoint) offlineContainers() ([]*offlineContainer, error) {
+	dirs, err := os.ReadDir(filepath.Join(ep.dataRoot(), "containers"))
+	if err != nil {
+		return nil, err
+	}
+	containers := []*offlineContainer{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		ctr, err := readOfflineContainer(filepath.Join(ep.dataRoot(), "containers", dir.Name()))
+		if err != nil {
+			slog.Debug("读取容器配置失败", "dir", dir.Name(), "err", err)
+			continue
+		}
+		containers = append(containers, ctr)
+	}
+	return containers, nil
+}
+
+func (ep *Endpoint) listOffline(lq *ListQuery) ([]*Container, error) {
+	ctrs, err := ep.offlineContainers()
+	if err != nil {
+		return nil, err
+	}
+	containers := []*Container{}
+	for _, oc := range ctrs {
+		ctr := oc.toContainer(ep.Name())
+		if (lq.All || ctr.State == "running") && lq.MatchState(ctr) && lq.MatchLabels(ctr) && lq.Match(ctr) {
+			containers = append(containers, ctr)
+		}
+	}
+	return containers, nil
+}
+
+// findOffline 按ID、ID前缀或名称查找容器，没有 id 时按 target 查找，优先返回运行中的，其次是最新创建的
+func (ep *Endpoint) findOffline(id string, t *followTarget) (*offlineContainer, error) {
+	ctrs, err := ep.offlineContainers()
+	if err != nil {
+		return nil, err
+	}
+	match := func(ctr *offlineContainer) bool {
+		if t == nil {
+			return strings.HasPrefix(ctr.ID, id) || strings.TrimPrefix(ctr.Name, "/") == id
+		}
+		labels := ctr.Config.Labels
+		return t.match([]string{ctr.Name}) &&
+			(len(t.Service) == 0 || labels[ComposeServiceLabel] == t.Service) &&
+			(len(t.Project) == 0 || labels[ComposeProjectLabel] == t.Project)
+	}
+	var found *offlineContainer
+	for _, ctr := range ctrs {
+		if !match(ctr) {
+			continue
+		}
+		if found == nil || ctr.State.Running && !found.State.Running ||
+			ctr.State.Running == found.State.Running && ctr.Created.After(found.Created) {
+			found = ctr
+		}
+	}
+	if found == nil {
+		if t != nil {
+			id = t.String()
+		}
+		return nil, errors.Wrap(ErrContainerNotFound, id)
+	}
+	return found, nil
+}
+
+// attachOffline 从数据目录读取容器配置，返回容器标签
+func (src *logSource) attachOffline(ep *Endpoint) (map[string]string, error) {
+	ctr, err := ep.findOffline(src.id, src.target)
+	if err != nil {
+		return nil, err
+	}
+	src.id = ctr.ID
+	src.tty = ctr.Config.Tty
+	src.started = ctr.State.StartedAt
+	switch ctr.driver {
+	case LogDriverJSONFile, LogDriverLocal:
+		src.file = &logFile{driver: ctr.driver, path: ctr.logPath()}
+	case "journald":
+		src.journal = src.local
+	}
+	if src.file == nil && !src.journal {
+		return nil, errors.Wrap(ErrUnsupportDriver, ctr.driver)
+	}
+	return ctr.Config.Labels, nil
+}
+
+// logFile 直接读取的日志文件
+type logFile struct {
+	driver string
+	path   string
+}
+
+// getClientOffline 连接失败但可以读取数据目录时返回 nil 连接，此时直接读取日志文件
+func (s *Server) getClientOffline(ctx context.Context, q url.Values) (*Endpoint, *client.Client, error) {
+	ep, client, err := s.getClient(ctx, q)
+	if err != nil && ep != nil && len(ep.dataRoot()) > 0 {
+		slog.Warn("docker不可用，直接读取日志文件", "endpoint", ep.Name(), "err", err)
+		return ep, nil, nil
+	}
+	return ep, client, err
+}
+
+// fileOptions 转换为直接读取日志文件的选项
+func fileOptions(opts container.LogsOptions) LogFileOptions {
+	// 与 docker 相同，空字符串、all 和无效的值都读取全部
+	tail, err := strconv.ParseInt(opts.Tail, 10, 64)
+	if err != nil || tail < 0 {
+		tail = -1
+	}
+	return LogFileOptions{
+		Tail:   tail,
+		Since:  parseDockerTimestamp(opts.Since),
+		Until:  parseDockerTimestamp(opts.Until),
+		Follow: opts.Follow,
+		Stdout: opts.ShowStdout,
+		Stderr: opts.ShowStderr,
+	}
+}
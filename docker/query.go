@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+	containerStates = []string{"created", "restarting", "running", "removing", "paused", "exited", "dead"}
+)
+
+// ListQuery 容器列表的过滤条件，状态和标签交给docker过滤，名称正则在本地匹配
+type ListQuery struct {
+	All     bool
+	States  []string
+	Labels  []string
+	Name    *regexp.Regexp
+	Project string
+}
+
+func invalidQuery(q url.Values, key string) error {
+	return errors.Wrapf(ErrInvalidQuery, "%s=%q", key, q.Get(key))
+}
+
+// queryList 支持重复参数和逗号分隔
+func queryList(q url.Values, key string) []string {
+	values := []string{}
+	for _, v := range q[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+func ParseListQuery(q url.Values) (*ListQuery, error) {
+	lq := &ListQuery{
+		All:     AllContainer,
+		States:  queryList(q, "state"),
+		Project: q.Get("project"),
+	}
+	for _, state := range lq.States {
+		if !slices.Contains(containerStates, state) {
+			return nil, errors.Wrapf(ErrInvalidQuery, "state=%q", state)
+		}
+	}
+	// 指定状态时需要列出非运行中的容器
+	if len(lq.States) > 0 {
+		lq.All = true
+	}
+	var err error
+	if q.Has("all") {
+		if lq.All, err = strconv.ParseBool(q.Get("all")); err != nil {
+			return nil, invalidQuery(q, "all")
+		}
+	}
+	// 标签不能按逗号拆分，值中可能包含逗号
+	lq.Labels = q["label"]
+	if q.Has("name") {
+		if lq.Name, err = regexp.Compile(q.Get("name")); err != nil {
+			return nil, invalidQuery(q, "name")
+		}
+	}
+	return lq, nil
+}
+
+func (lq *ListQuery) Options() container.ListOptions {
+	args := filters.NewArgs()
+	for _, state := range lq.States {
+		args.Add("status", state)
+	}
+	for _, label := range lq.Labels {
+		args.Add("label", label)
+	}
+	if len(lq.Project) > 0 {
+		args.Add("label", ComposeProjectLabel+"="+lq.Project)
+	}
+	return container.ListOptions{All: lq.All, Filters: args}
+}
+
+func (lq *ListQuery) Match(ctr *Container) bool {
+	return lq.Name == nil || lq.Name.MatchString(ctr.Name)
+}
+
+// MatchState 用于容器事件，列表请求的状态已由docker过滤
+func (lq *ListQuery) MatchState(ctr *Container) bool {
+	return len(lq.States) == 0 || slices.Contains(lq.States, ctr.State)
+}
+
+// MatchLabels 直接读取数据目录时在本地匹配标签和 compose 项目
+func (lq *ListQuery) MatchLabels(ctr *Container) bool {
+	for _, label := range lq.Labels {
+		k, v, hasValue := strings.Cut(label, "=")
+		if value, ok := ctr.Labels[k]; !ok || hasValue && value != v {
+			return false
+		}
+	}
+	return len(lq.Project) == 0 || ctr.Project == lq.Project
+}
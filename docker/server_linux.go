@@ -3,51 +3,313 @@
 package docker
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/boringcat/just-a-log-viewer/journald"
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/boringcat/just-a-log-viewer/server"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
 )
 
 type Container struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Endpoint     string            `json:"endpoint"`
+	Image        string            `json:"image"`
+	State        string            `json:"state"`
+	Status       string            `json:"status"`
+	Created      time.Time         `json:"created"`
+	RestartCount int               `json:"restart_count"`
+	Project      string            `json:"project,omitempty"`
+	Service      string            `json:"service,omitempty"`
+	Pod          string            `json:"pod,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// MarshalJSON 标签中可能有密码等敏感信息，列表和事件中输出前隐藏，本地匹配标签时使用原始值
+func (c *Container) MarshalJSON() ([]byte, error) {
+	type plain Container
+	masked := plain(*c)
+	masked.Labels = maskLabels(c.Labels)
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(&masked)
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), err
+}
+
+// 查询重启次数时同时进行的 inspect 请求数
+const inspectConcurrency = 8
+
+func NewContainer(ctr *container.Summary) *Container {
+	c := &Container{
+		ID:      ctr.ID,
+		Image:   ctr.Image,
+		State:   ctr.State,
+		Status:  ctr.Status,
+		Created: time.Unix(ctr.Created, 0),
+		Project: ctr.Labels[ComposeProjectLabel],
+		Service: ctr.Labels[ComposeServiceLabel],
+		Labels:  ctr.Labels,
+	}
+	if len(ctr.Names) > 0 {
+		c.Name = strings.TrimPrefix(ctr.Names[0], "/")
+	}
+	return c
 }
 
 type Server struct {
-	client *client.Client
+	endpoints []*Endpoint
+	files     []string
 }
 
 func NewServer() (server.LogServer, error) {
-	if Enabled {
-		return &Server{}, nil
+	if !Enabled {
+		return nil, nil
+	}
+	conf, err := ReadConfig(ConfigFilePath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{files: conf.Files}
+	for _, ep := range conf.Endpoints {
+		s.endpoints = append(s.endpoints, NewEndpoint(ep))
+	}
+	return s, nil
+}
+
+// getEndpoints 请求中指定 endpoint 时只返回该连接，否则返回全部
+func (s *Server) getEndpoints(q url.Values) ([]*Endpoint, error) {
+	if !q.Has("endpoint") {
+		return s.endpoints, nil
+	}
+	for _, ep := range s.endpoints {
+		if ep.Name() == q.Get("endpoint") {
+			return []*Endpoint{ep}, nil
+		}
 	}
-	return nil, nil
+	return nil, errors.Wrapf(ErrInvalidEndpoint, "%q not found", q.Get("endpoint"))
 }
 
-func (s *Server) getClient(ctx context.Context) (*client.Client, error) {
-	if s.client == nil {
-		apiClient, err := client.NewClientWithOpts(client.FromEnv)
+// getClient 获取请求指定的连接，没有指定时使用第一个
+func (s *Server) getClient(ctx context.Context, q url.Values) (*Endpoint, *client.Client, error) {
+	endpoints, err := s.getEndpoints(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := endpoints[0].getClient(ctx)
+	return endpoints[0], client, err
+}
+
+// logSource 读取一个容器日志所需的信息
+type logSource struct {
+	id      string
+	target  *followTarget
+	tty     bool
+	started time.Time
+	// 容器使用journald日志驱动并且journal在本机
+	journal bool
+	// docker 不可用时直接读取的日志文件
+	file     *logFile
+	local    bool
+	podman   bool
+	pipeline *parser.Pipeline
+	ml       *parser.Multiline
+	opts     container.LogsOptions
+	labels   map[string]string
+}
+
+// getLogSource 查询容器是否使用TTY，并获取日志的解析器和多行规则，优先级：请求参数 > 容器标签 > 全局配置。
+// 没有 id 时按 name 或 compose 服务查找容器，client 为 nil 时从数据目录查找
+func (s *Server) getLogSource(ctx context.Context, ep *Endpoint, client *client.Client, q url.Values) (*logSource, error) {
+	src := &logSource{id: q.Get("id"), local: ep.local(), podman: ep.isPodman()}
+	var err error
+	if src.target, err = parseTarget(q); err != nil {
+		return nil, err
+	}
+	if src.opts.ShowStdout, src.opts.ShowStderr, err = ParseStream(q.Get("stream")); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for key, dst := range map[string]*string{"since": &src.opts.Since, "until": &src.opts.Until} {
+		if !q.Has(key) {
+			continue
+		}
+		t, err := server.ParseTime(q.Get(key), now)
 		if err != nil {
 			return nil, err
 		}
-		s.client = apiClient
+		*dst = dockerTime(t)
+	}
+	for key, dst := range map[string]*bool{"timestamps": &src.opts.Timestamps, "details": &src.opts.Details} {
+		if !q.Has(key) {
+			continue
+		}
+		if *dst, err = strconv.ParseBool(q.Get(key)); err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+	}
+	var labels map[string]string
+	if client == nil {
+		if labels, err = src.attachOffline(ep); err != nil {
+			return nil, err
+		}
 	} else {
-		if _, err := s.client.Ping(ctx); err != nil {
-			s.client.Close()
-			s.client = nil
-			if _, err = s.getClient(ctx); err != nil {
+		if src.target != nil {
+			if src.id, err = src.target.resolve(ctx, client); err != nil {
 				return nil, err
 			}
 		}
+		info, err := src.attach(ctx, client, src.id)
+		if err != nil {
+			return nil, err
+		}
+		if info.Config != nil {
+			labels = info.Config.Labels
+		}
+	}
+	src.labels = labels
+	conf := &parser.Config{Type: DefaultParser}
+	if len(labels[ParserLabel]) > 0 {
+		conf.Type = labels[ParserLabel]
+	}
+	if src.ml, err = parser.NewMultiline(labels[MultilineStartLabel], labels[MultilineContinueLabel]); err != nil {
+		return nil, err
+	}
+	if src.pipeline, err = parser.FromQuery(q, conf); err != nil {
+		return nil, err
+	}
+	if src.ml, err = parser.MultilineFromQuery(q, src.ml); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// decode 拆出 timestamps 和 details 加在每行前面的时间戳和日志驱动属性，属性放到标签中
+func (src *logSource) decode(e *parser.Entry) {
+	if src.opts.Timestamps {
+		if ts, msg, ok := strings.Cut(e.Message, " "); ok {
+			if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				e.TimeStamp = float64(t.UnixNano()) / 1e6
+				e.Message = msg
+			}
+		}
+	}
+	if src.opts.Details {
+		if attrs, msg, ok := strings.Cut(e.Message, " "); ok {
+			e.Message = msg
+			for _, attr := range strings.Split(attrs, ",") {
+				k, v, ok := strings.Cut(attr, "=")
+				if !ok {
+					continue
+				}
+				if e.Labels == nil {
+					e.Labels = map[string]string{}
+				}
+				k, _ = url.QueryUnescape(k)
+				v, _ = url.QueryUnescape(v)
+				e.Labels[k] = v
+			}
+		}
+	}
+}
+
+func (src *logSource) scan(rd io.Reader, fn func(*parser.Entry) bool) error {
+	return scanEntries(rd, src.tty, func(e *parser.Entry) bool {
+		src.decode(e)
+		return fn(e)
+	})
+}
+
+// open 打开日志流。容器的journald日志在本机时，Podman 直接读取journal，docker 在接口读取失败时改为读取journal，
+// docker 不可用时直接读取日志文件，此时返回 nil
+func (src *logSource) open(ctx context.Context, client *client.Client, opts container.LogsOptions) (io.ReadCloser, error) {
+	if client == nil || src.journal && src.podman {
+		return nil, nil
+	}
+	rd, err := client.ContainerLogs(ctx, src.id, opts)
+	if err != nil && src.journal {
+		slog.Debug("读取容器日志失败，改为读取journald", "id", src.id, "err", err)
+		return nil, nil
+	}
+	return rd, err
+}
+
+// read 读取 open 返回的日志流并关闭
+func (src *logSource) read(ctx context.Context, rd io.ReadCloser, opts container.LogsOptions, fn func(*parser.Entry) bool) error {
+	if rd != nil {
+		defer rd.Close()
+		return src.scan(rd, fn)
+	} else if src.file != nil {
+		return ReadLogFiles(ctx, src.file.driver, src.file.path, fileOptions(opts), fn)
+	}
+	jopts := journald.ReadOptions{
+		Matches: []string{"CONTAINER_ID_FULL=" + src.id},
+		Since:   parseDockerTimestamp(opts.Since),
+		Until:   parseDockerTimestamp(opts.Until),
+		Follow:  opts.Follow,
+	}
+	if jopts.Tail, _ = strconv.ParseUint(opts.Tail, 10, 64); opts.Tail == "0" {
+		// 与 docker 相同，tail 为 0 时不读取历史日志
+		jopts.Since = time.Now()
+	}
+	return journald.ReadEntries(ctx, jopts, func(e *parser.Entry) bool {
+		if e.Stream == StreamStdout && !opts.ShowStdout || e.Stream == StreamStderr && !opts.ShowStderr {
+			return true
+		}
+		return fn(e)
+	})
+}
+
+// getTailLines 多行合并时 tail 按事件计数，逐步加大读取的行数直到包含足够的完整事件，
+// 返回需要向docker请求的行数和合并后需要跳过的事件数
+func getTailLines(ctx context.Context, client *client.Client, src *logSource, tail string) (string, int64, error) {
+	events, err := strconv.ParseInt(tail, 10, 64)
+	if src.ml == nil || err != nil || events <= 0 {
+		return tail, 0, nil
+	}
+	physical := events + 1
+	for {
+		opts := src.opts
+		opts.Tail = strconv.FormatInt(physical, 10)
+		rd, err := src.open(ctx, client, opts)
+		if err != nil {
+			return "", 0, err
+		}
+		g := src.ml.NewGrouper()
+		counter := parser.EventCounter{}
+		var lines int64 = 0
+		err = src.read(ctx, rd, opts, func(e *parser.Entry) bool {
+			lines++
+			if done := g.Add(e); done != nil {
+				counter.Add(done)
+			}
+			return true
+		})
+		if err != nil {
+			return "", 0, err
+		}
+		for _, e := range g.Flush() {
+			counter.Add(e)
+		}
+		if counter.Complete >= events || lines < physical {
+			return opts.Tail, max(counter.Count-events, 0), nil
+		}
+		physical *= 2
 	}
-	return s.client, nil
 }
 
 func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
@@ -55,16 +317,30 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 		server.HTTPError(w, http.StatusMethodNotAllowed)
 		return
 	}
-	client, err := s.getClient(r.Context())
+	q := r.URL.Query()
+	endpoints, err := s.getEndpoints(q)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	containers, err := client.ContainerList(r.Context(), container.ListOptions{All: AllContainer})
+	lq, err := ParseListQuery(q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	containers := []*Container{}
+	for _, ep := range endpoints {
+		ctrs, err := ep.listContainers(r.Context(), lq)
+		if err != nil && len(endpoints) == 1 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if err != nil {
+			// 多个连接时跳过不可用的连接
+			slog.Warn("获取容器列表失败", "endpoint", ep.Name(), "err", err)
+			continue
+		}
+		containers = append(containers, ctrs...)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	sep := "["
@@ -72,10 +348,7 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	enc.SetEscapeHTML(false)
 	for _, ctr := range containers {
 		fmt.Fprint(w, sep)
-		enc.Encode(Container{
-			ID:   ctr.ID,
-			Name: strings.TrimPrefix(ctr.Names[0], "/"),
-		})
+		enc.Encode(ctr)
 		sep = ","
 	}
 	if sep == "[" {
@@ -84,46 +357,111 @@ func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "]")
 }
 
+func (ep *Endpoint) listContainers(ctx context.Context, lq *ListQuery) ([]*Container, error) {
+	client, err := ep.getClient(ctx)
+	if err != nil && len(ep.dataRoot()) > 0 {
+		slog.Warn("docker不可用，从数据目录读取容器列表", "endpoint", ep.Name(), "err", err)
+		return ep.listOffline(lq)
+	} else if err != nil {
+		return nil, err
+	}
+	summaries, err := client.ContainerList(ctx, lq.Options())
+	if err != nil {
+		return nil, err
+	}
+	containers := []*Container{}
+	pods := map[string]string{}
+	if ep.isPodman() {
+		if pods, err = ep.listPods(ctx, client); err != nil {
+			slog.Debug("获取pod列表失败", "endpoint", ep.Name(), "err", err)
+		}
+	}
+	for i := range summaries {
+		if ctr := NewContainer(&summaries[i]); lq.Match(ctr) {
+			ctr.Endpoint = ep.Name()
+			ctr.Pod = pods[ctr.ID]
+			containers = append(containers, ctr)
+		}
+	}
+	fillRestartCount(ctx, client, containers)
+	return containers, nil
+}
+
+// fillRestartCount 重启次数只能通过 inspect 获取
+func fillRestartCount(ctx context.Context, client *client.Client, containers []*Container) {
+	sem := make(chan struct{}, inspectConcurrency)
+	wg := sync.WaitGroup{}
+	for _, ctr := range containers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			info, err := client.ContainerInspect(ctx, ctr.ID)
+			if err != nil {
+				slog.Debug("获取容器信息失败", "id", ctr.ID, "err", err)
+				return
+			}
+			ctr.RestartCount = info.RestartCount
+		}()
+	}
+	wg.Wait()
+}
+
 func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		server.HTTPError(w, http.StatusMethodNotAllowed)
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "id"); err != nil {
+
+	ep, client, err := s.getClientOffline(r.Context(), q)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	client, err := s.getClient(r.Context())
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	opts := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
+	src, err := s.getLogSource(r.Context(), ep, client, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	var skip int64 = 0
 	if q.Has("tail") {
-		opts.Tail = q.Get("tail")
+		if src.opts.Tail, skip, err = getTailLines(r.Context(), client, src, q.Get("tail")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	rd, err := client.ContainerLogs(r.Context(), q.Get("id"), opts)
+	rd, err := src.open(r.Context(), client, src.opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rd.Close()
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	scanner := bufio.NewScanner(rd)
-	for scanner.Scan() {
-		buf := scanner.Bytes()
-		if len(buf) <= 8 {
-			continue
+	w.Header().Set("Content-Type", ew.ContentType())
+	g := src.ml.NewGrouper()
+	write := func(e *parser.Entry) {
+		if skip > 0 {
+			skip--
+		} else if src.pipeline.Apply(e) {
+			ew.Write(e)
+		}
+	}
+	if err = src.read(r.Context(), rd, src.opts, func(e *parser.Entry) bool {
+		if e = g.Add(e); e != nil {
+			write(e)
 		}
-		w.Write(buf[8:])
-		fmt.Fprintln(w)
+		return true
+	}); err != nil {
+		slog.Debug("读取容器日志异常", "id", src.id, "err", err)
+	}
+	for _, e := range g.Flush() {
+		write(e)
 	}
 }
 
@@ -133,10 +471,6 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "id"); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -144,43 +478,87 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client, err := s.getClient(r.Context())
+	ep, client, err := s.getClientOffline(r.Context(), q)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	opts := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     true,
+	ew, err := parser.NewWriter(w, q.Get("format"), q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	src, err := s.getLogSource(r.Context(), ep, client, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	src.opts.Follow = true
+	var skip int64 = 0
 	if q.Has("tail") {
-		opts.Tail = q.Get("tail")
+		if src.opts.Tail, skip, err = getTailLines(r.Context(), client, src, q.Get("tail")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	rd, err := client.ContainerLogs(r.Context(), q.Get("id"), opts)
+	rd, err := src.open(r.Context(), client, src.opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rd.Close()
 
 	w.Header().Set("Transfer-Encoding", "chunked")
 	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	flusher.Flush()
 
-	scanner := bufio.NewScanner(rd)
-	for scanner.Scan() {
-		buf := scanner.Bytes()
-		if len(buf) <= 8 {
-			continue
+	items := make(chan watchItem)
+	go followLogs(r.Context(), client, src, rd, items)
+
+	enc := json.NewEncoder(w)
+	g := src.ml.NewGrouper()
+	flushTicker := time.NewTicker(time.Hour)
+	if g != nil && g.Timeout() > 0 {
+		flushTicker.Reset(g.Timeout() / 2)
+	} else {
+		flushTicker.Stop()
+	}
+	defer flushTicker.Stop()
+	write := func(entries ...*parser.Entry) {
+		for _, e := range entries {
+			if e == nil {
+				continue
+			} else if skip > 0 {
+				skip--
+			} else if src.pipeline.Apply(e) {
+				ew.WriteEvent("", e)
+			}
 		}
-		fmt.Fprintf(w, "data: ")
-		w.Write(buf[8:])
-		fmt.Fprint(w, "\n\n")
 		flusher.Flush()
 	}
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				write(g.Flush()...)
+				return
+			}
+			if item.notice != nil {
+				write(g.Flush()...)
+				fmt.Fprint(w, "event: container\ndata: ")
+				enc.Encode(item.notice)
+				fmt.Fprint(w, "\n")
+				flusher.Flush()
+				continue
+			}
+			write(g.Add(item.entry))
+		case <-flushTicker.C:
+			write(g.FlushStale()...)
+		case <-r.Context().Done():
+			return
+		}
+	}
 }
 
 func init() {
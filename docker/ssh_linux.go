@@ -0,0 +1,116 @@
+//go:build linux
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sshDialer 通过 ssh 在远端执行 docker system dial-stdio，与 docker cli 的 ssh:// 连接方式相同
+func sshDialer(host string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if len(u.Port()) > 0 {
+		args = append(args, "-p", u.Port())
+	}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// 连接会放回连接池，不能跟随请求的 ctx 结束
+		var err error
+		cmd := exec.Command("ssh", args...)
+		conn := &commandConn{cmd: cmd}
+		cmd.Stderr = &conn.stderr
+		if conn.stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+		if conn.stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, errors.Wrap(err, "ssh")
+		}
+		return conn, nil
+	}, nil
+}
+
+// lockedBuffer exec 在单独的协程中写入错误输出
+type lockedBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// commandConn 把子进程的标准输入输出包装为 net.Conn
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr lockedBuffer
+	wait   sync.Once
+}
+
+func (c *commandConn) stop() {
+	c.wait.Do(func() {
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err == io.EOF {
+		// 等待进程退出，确保错误输出已经读完
+		c.stop()
+		if stderr := bytes.TrimSpace(c.stderr.Bytes()); len(stderr) > 0 {
+			return n, errors.Errorf("%s", stderr)
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stop()
+	return nil
+}
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "ssh" }
+func (commandAddr) String() string  { return "ssh" }
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/pkg/errors"
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+var (
+	ErrInvalidStream = errors.New("invalid stream")
+	ErrBadFrame      = errors.New("bad log frame")
+)
+
+// ParseStream 解析 stream 参数，返回是否读取 stdout 和 stderr
+func ParseStream(s string) (stdout bool, stderr bool, err error) {
+	switch s {
+	case "":
+		return true, true, nil
+	case StreamStdout:
+		return true, false, nil
+	case StreamStderr:
+		return false, true, nil
+	}
+	return false, false, errors.Wrap(ErrInvalidStream, s)
+}
+
+// scanEntries 逐行读取容器日志。
+// 非TTY容器的日志按帧输出，每帧有8字节的头：1字节流类型、3字节填充、4字节大端长度，
+// 一帧可能包含多行，一行也可能跨越多帧；TTY容器没有帧头，所有输出都算作 stdout
+func scanEntries(rd io.Reader, tty bool, fn func(*parser.Entry) bool) error {
+	if tty {
+		return scanRaw(rd, fn)
+	}
+	header := make([]byte, 8)
+	payload := []byte{}
+	pending := map[string][]byte{}
+	emit := func(stream string, line []byte) bool {
+		return fn(&parser.Entry{Stream: stream, Message: string(line)})
+	}
+	for {
+		if _, err := io.ReadFull(rd, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[4:])
+		if cap(payload) < int(size) {
+			payload = make([]byte, size)
+		}
+		payload = payload[:size]
+		if _, err := io.ReadFull(rd, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		var stream string
+		switch header[0] {
+		case 0, 1:
+			stream = StreamStdout
+		case 2:
+			stream = StreamStderr
+		case 3:
+			// 守护进程返回的错误
+			return errors.New(string(payload))
+		default:
+			return errors.Wrapf(ErrBadFrame, "stream type %d", header[0])
+		}
+		buf := append(pending[stream], payload...)
+		for {
+			idx := bytes.IndexByte(buf, '\n')
+			if idx < 0 {
+				break
+			}
+			if !emit(stream, buf[:idx]) {
+				return nil
+			}
+			buf = buf[idx+1:]
+		}
+		pending[stream] = append([]byte{}, buf...)
+	}
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		if len(pending[stream]) > 0 && !emit(stream, pending[stream]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func scanRaw(rd io.Reader, fn func(*parser.Entry) bool) error {
+	br := bufio.NewReader(rd)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if !fn(&parser.Entry{Stream: StreamStdout, Message: line}) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
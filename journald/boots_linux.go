@@ -0,0 +1,139 @@
+//go:build linux && cgo
+
+package journald
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/pkg/errors"
+)
+
+// Boot 与 journalctl --list-boots 相同，Index 0 为最近一次启动，之前的依次为 -1、-2……
+type Boot struct {
+	Index int       `json:"index"`
+	ID    string    `json:"id"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// bootRange 获取当前匹配条件下第一条和最后一条日志的时间
+func bootRange(j *sdjournal.Journal) (first, last time.Time, err error) {
+	var usec uint64
+	if err = j.SeekHead(); err != nil {
+		return
+	} else if _, err = j.Next(); err != nil {
+		return
+	} else if usec, err = j.GetRealtimeUsec(); err != nil {
+		return
+	}
+	first = time.UnixMicro(int64(usec))
+	if err = j.SeekTail(); err != nil {
+		return
+	} else if _, err = j.Previous(); err != nil {
+		return
+	} else if usec, err = j.GetRealtimeUsec(); err != nil {
+		return
+	}
+	last = time.UnixMicro(int64(usec))
+	return
+}
+
+// ListBoots 按时间从旧到新列出journal中的所有启动
+func ListBoots() ([]*Boot, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+	ids, err := j.GetUniqueValues(sdjournal.SD_JOURNAL_FIELD_BOOT_ID)
+	if err != nil {
+		return nil, err
+	}
+	boots := []*Boot{}
+	for _, id := range ids {
+		j.FlushMatches()
+		if err = j.AddMatch(sdjournal.SD_JOURNAL_FIELD_BOOT_ID + "=" + id); err != nil {
+			return nil, err
+		}
+		first, last, err := bootRange(j)
+		if err != nil {
+			// 日志已被轮转清理
+			continue
+		}
+		boots = append(boots, &Boot{ID: id, First: first, Last: last})
+	}
+	slices.SortFunc(boots, func(a, b *Boot) int { return a.First.Compare(b.First) })
+	for idx, boot := range boots {
+		boot.Index = idx - len(boots) + 1
+	}
+	return boots, nil
+}
+
+// ResolveBoot 与 journalctl -b 相同，参数为启动ID或偏移，0 和负数从最近一次启动往前数，正数从第一次启动往后数
+func ResolveBoot(s string) (string, error) {
+	if id := strings.ReplaceAll(strings.ToLower(s), "-", ""); bootIDPattern.MatchString(id) {
+		return id, nil
+	}
+	offset, err := strconv.Atoi(s)
+	if err != nil {
+		return "", invalidQuery("boot", s)
+	}
+	boots, err := ListBoots()
+	if err != nil {
+		return "", err
+	}
+	idx := len(boots) - 1 + offset
+	if offset > 0 {
+		idx = offset - 1
+	}
+	if idx < 0 || idx >= len(boots) {
+		return "", errors.Wrapf(ErrInvalidQuery, "boot %q not found", s)
+	}
+	return boots[idx].ID, nil
+}
+
+// parseMatches 在 ParseMatches 的基础上限定在 boot 指定的启动内
+func parseMatches(q url.Values) (Matches, error) {
+	if !q.Has("boot") {
+		return ParseMatches(q)
+	}
+	id, err := ResolveBoot(q.Get("boot"))
+	if err != nil {
+		return nil, err
+	}
+	matches, err := ParseMatches(q)
+	if err != nil && !errors.Is(err, server.ErrMissingKeys) {
+		return nil, err
+	}
+	return append(matches, MatchSection{{sdjournal.SD_JOURNAL_FIELD_BOOT_ID + "=" + id}}), nil
+}
+
+func (s *Server) HandleBoots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.HTTPError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	boots, err := ListBoots()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(boots)
+}
+
+func (s *Server) Handlers() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"boots": s.HandleBoots,
+	}
+}
@@ -1,6 +1,80 @@
 package journald
 
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	UnitSourceSystem     = "system"
+	UnitSourceUser       = "user"
+	UnitSourceIdentifier = "identifier"
+	UnitSourceKernel     = "kernel"
+
+	// 标识符和内核日志没有单元类型
+	UnitTypeIdentifier = "identifier"
+	UnitTypeKernel     = "kernel"
+	UnitTypeOther      = "other"
+
+	// tail 响应中本页第一条和最后一条journal的cursor，用于 before 和 after 翻页
+	HeaderFirstCursor = "X-Journal-First-Cursor"
+	HeaderLastCursor  = "X-Journal-Last-Cursor"
+)
+
+// unitFields 枚举这些字段的值作为日志来源
+var unitFields = []struct {
+	field  string
+	source string
+}{
+	{"_SYSTEMD_UNIT", UnitSourceSystem},
+	{"_SYSTEMD_USER_UNIT", UnitSourceUser},
+	{"SYSLOG_IDENTIFIER", UnitSourceIdentifier},
+}
+
 var (
 	SystemdUnitState string
 	Enabled          bool
+	DefaultParser    string
+
+	ErrUnsupported = errors.New("journald is not supported on this build")
 )
+
+// ReadOptions 供其他模块读取journal，Matches 为 FIELD=value 格式，之间为“与”关系
+type ReadOptions struct {
+	Matches []string
+	Tail    uint64
+	Since   time.Time
+	Until   time.Time
+	Follow  bool
+}
+
+// unitType 单元类型为名称的后缀，如 service、timer、socket、scope
+func unitType(name, source string) string {
+	if source == UnitSourceIdentifier {
+		return UnitTypeIdentifier
+	}
+	if idx := strings.LastIndexByte(name, '.'); idx >= 0 && idx < len(name)-1 {
+		return name[idx+1:]
+	}
+	return UnitTypeOther
+}
+
+// ParseStates 请求参数 state 优先于 --systemd-unit-state，支持重复参数和逗号分隔，为空时不过滤
+func ParseStates(q url.Values) []string {
+	values := []string{SystemdUnitState}
+	if q.Has("state") {
+		values = q["state"]
+	}
+	states := []string{}
+	for _, v := range values {
+		for _, state := range strings.Split(v, ",") {
+			if state = strings.TrimSpace(state); len(state) > 0 {
+				states = append(states, state)
+			}
+		}
+	}
+	return states
+}
@@ -0,0 +1,183 @@
+package journald
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// 与 journalctl -p 相同的优先级名称
+	priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	fieldPattern  = regexp.MustCompile(`^[A-Z0-9_]+$`)
+	bootIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	// 查询参数与journal字段的对应关系，同一参数的多个值为“或”
+	fieldQueries = []struct {
+		key     string
+		field   string
+		numeric bool
+	}{
+		{"identifier", "SYSLOG_IDENTIFIER", false},
+		{"pid", "_PID", true},
+		{"uid", "_UID", true},
+		{"boot_id", "_BOOT_ID", false},
+		{"comm", "_COMM", false},
+		{"transport", "_TRANSPORT", false},
+	}
+)
+
+func invalidQuery(key, value string) error {
+	return errors.Wrapf(ErrInvalidQuery, "%s=%q", key, value)
+}
+
+// MatchGroup 组内的条件为“与”，同名字段之间为“或”
+type MatchGroup []string
+
+// MatchSection 组之间为“或”
+type MatchSection []MatchGroup
+
+// Matches journal 的查询条件，各部分之间为“与”
+type Matches []MatchSection
+
+// parsePriority 解析 err、3 或 err..emerg 形式的优先级，单个值表示该优先级及更高
+func parsePriority(s string) ([]string, error) {
+	level := func(name string) (int, error) {
+		for idx, n := range priorityNames {
+			if n == name {
+				return idx, nil
+			}
+		}
+		if val, err := strconv.Atoi(name); err == nil && val >= 0 && val < len(priorityNames) {
+			return val, nil
+		}
+		return 0, invalidQuery("priority", s)
+	}
+	from, to, isRange := strings.Cut(strings.ToLower(s), "..")
+	low, err := level(from)
+	if err != nil {
+		return nil, err
+	}
+	high := 0
+	if isRange {
+		if high, err = level(to); err != nil {
+			return nil, err
+		}
+	}
+	if low < high {
+		low, high = high, low
+	}
+	matches := []string{}
+	for p := high; p <= low; p++ {
+		matches = append(matches, "PRIORITY="+strconv.Itoa(p))
+	}
+	return matches, nil
+}
+
+// parseMatch 解析 FIELD=value 形式的条件
+func parseMatch(s string) (string, error) {
+	field, _, ok := strings.Cut(s, "=")
+	if !ok || !fieldPattern.MatchString(field) {
+		return "", invalidQuery("match", s)
+	}
+	return s, nil
+}
+
+// ParseMatches 按请求参数生成查询条件：
+//   - name 为单元名或标识符，dmesg 和 kernel 表示内核日志
+//   - priority、identifier、pid、uid、boot_id、comm、transport 为对应字段
+//   - match 为任意 FIELD=value，值为 + 时与 journalctl 相同，表示前后两组条件为“或”。
+//     查询字符串中未编码的 + 会被解码为空格，因此空格和空值(match=)也作为分隔
+func ParseMatches(q url.Values) (Matches, error) {
+	matches := Matches{}
+	if name := q.Get("name"); name == "kernel" || name == "dmesg" {
+		matches = append(matches, MatchSection{{"_TRANSPORT=kernel"}})
+	} else if len(name) > 0 {
+		// 与 unitFields 对应，UNIT 和 USER_UNIT 为systemd关于该单元的消息
+		matches = append(matches, MatchSection{
+			{"_SYSTEMD_UNIT=" + name}, {"UNIT=" + name},
+			{"_SYSTEMD_USER_UNIT=" + name}, {"USER_UNIT=" + name},
+			{"SYSLOG_IDENTIFIER=" + name},
+		})
+	}
+	fields := MatchGroup{}
+	for _, v := range q["priority"] {
+		priorities, err := parsePriority(v)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, priorities...)
+	}
+	for _, fq := range fieldQueries {
+		for _, v := range q[fq.key] {
+			if fq.numeric {
+				if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+					return nil, invalidQuery(fq.key, v)
+				}
+			} else if fq.key == "boot_id" {
+				if v = strings.ReplaceAll(strings.ToLower(v), "-", ""); !bootIDPattern.MatchString(v) {
+					return nil, invalidQuery(fq.key, v)
+				}
+			}
+			fields = append(fields, fq.field+"="+v)
+		}
+	}
+	if len(fields) > 0 {
+		matches = append(matches, MatchSection{fields})
+	}
+	if q.Has("match") {
+		section := MatchSection{{}}
+		for _, v := range q["match"] {
+			if sep := strings.TrimSpace(v); len(sep) == 0 || sep == "+" {
+				section = append(section, MatchGroup{})
+				continue
+			}
+			m, err := parseMatch(v)
+			if err != nil {
+				return nil, err
+			}
+			section[len(section)-1] = append(section[len(section)-1], m)
+		}
+		for _, group := range section {
+			if len(group) == 0 {
+				return nil, invalidQuery("match", "+")
+			}
+		}
+		matches = append(matches, section)
+	}
+	if len(matches) == 0 {
+		return nil, server.MissingKeys("name")
+	}
+	return matches, nil
+}
+
+// PageQuery 按cursor翻页，before 读取该位置之前的日志，after 读取之后的，都不包含cursor本身
+type PageQuery struct {
+	Before string
+	After  string
+	Limit  uint64
+}
+
+// ParsePageQuery limit 为读取的journal条数，没有指定时与 tail 相同
+func ParsePageQuery(q url.Values, tail uint64) (*PageQuery, error) {
+	pq := &PageQuery{Before: q.Get("before"), After: q.Get("after"), Limit: tail}
+	if len(pq.Before) > 0 && len(pq.After) > 0 {
+		return nil, errors.Wrap(ErrInvalidQuery, "before and after are exclusive")
+	}
+	if q.Has("limit") {
+		var err error
+		if pq.Limit, err = strconv.ParseUint(q.Get("limit"), 10, 64); err != nil {
+			return nil, invalidQuery("limit", q.Get("limit"))
+		}
+	}
+	return pq, nil
+}
+
+func (pq *PageQuery) Paging() bool {
+	return len(pq.Before) > 0 || len(pq.After) > 0
+}
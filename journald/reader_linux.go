@@ -0,0 +1,82 @@
+//go:build linux && cgo
+
+package journald
+
+import (
+	"context"
+	"time"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+	"github.com/coreos/go-systemd/v22/sdjournal"
+)
+
+// ReadEntries 按字段读取本机journal，Stream 根据优先级区分，err(3)及以上为 stderr
+func ReadEntries(ctx context.Context, opts ReadOptions, fn func(*parser.Entry) bool) error {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	for _, m := range opts.Matches {
+		if err = j.AddMatch(m); err != nil {
+			return err
+		}
+	}
+	var n uint64
+	if opts.Tail > 0 {
+		if err = j.SeekTail(); err != nil {
+			return err
+		}
+		n, err = j.PreviousSkip(opts.Tail)
+	} else {
+		if opts.Since.IsZero() {
+			err = j.SeekHead()
+		} else {
+			err = j.SeekRealtimeUsec(uint64(opts.Since.UnixMicro()))
+		}
+		if err != nil {
+			return err
+		}
+		n, err = j.Next()
+	}
+	if err != nil {
+		return err
+	}
+	positioned := n > 0
+	for ctx.Err() == nil {
+		if positioned {
+			e, err := j.GetEntry()
+			if err != nil {
+				return err
+			}
+			ts := time.UnixMicro(int64(e.RealtimeTimestamp))
+			if !opts.Until.IsZero() && ts.After(opts.Until) {
+				return nil
+			}
+			if opts.Since.IsZero() || !ts.Before(opts.Since) {
+				stream := "stdout"
+				if p := e.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY]; len(p) == 1 && p[0] <= '3' {
+					stream = "stderr"
+				}
+				if !fn(&parser.Entry{
+					TimeStamp: float64(e.RealtimeTimestamp) / 1000,
+					Stream:    stream,
+					Message:   e.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE],
+				}) {
+					return nil
+				}
+			}
+		}
+		next, err := j.Next()
+		if err != nil {
+			return err
+		}
+		if positioned = next > 0; !positioned {
+			if !opts.Follow {
+				return nil
+			}
+			j.Wait(200 * time.Millisecond)
+		}
+	}
+	return nil
+}
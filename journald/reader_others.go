@@ -0,0 +1,13 @@
+//go:build !linux || !cgo
+
+package journald
+
+import (
+	"context"
+
+	"github.com/boringcat/just-a-log-viewer/parser"
+)
+
+func ReadEntries(ctx context.Context, opts ReadOptions, fn func(*parser.Entry) bool) error {
+	return ErrUnsupported
+}
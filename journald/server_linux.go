@@ -9,31 +9,54 @@ import (
 	"net/http"
 	"net/url"
 	"os/exec"
+	"slices"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/boringcat/just-a-log-viewer/parser"
 	"github.com/boringcat/just-a-log-viewer/server"
 	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/pkg/errors"
 )
 
 type Unit struct {
 	Name        string `json:"unit"`
+	Type        string `json:"type"`
+	Source      string `json:"source"`
 	Load        string `json:"load"`
 	Active      string `json:"active"`
 	State       string `json:"sub"`
 	Description string `json:"description"`
 }
 
+// MatchState known 为 false 表示 systemctl 不可用，此时不过滤。
+// SYSLOG_IDENTIFIER 和只存在于日志中的单元(已卸载等)没有状态，总是保留
+func (u *Unit) MatchState(states []string, known bool) bool {
+	if len(states) == 0 || !known || u.Type == UnitTypeIdentifier || len(u.Load) == 0 {
+		return true
+	}
+	return slices.Contains(states, u.Load) || slices.Contains(states, u.Active) || slices.Contains(states, u.State)
+}
+
 type Units []*Unit
 
-func (a Units) Len() int           { return len(a) }
-func (a Units) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a Units) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a Units) Len() int      { return len(a) }
+func (a Units) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a Units) Less(i, j int) bool {
+	if a[i].Type != a[j].Type {
+		return a[i].Type < a[j].Type
+	}
+	return a[i].Name < a[j].Name
+}
 
 type Server struct {
-	services  Units
-	lastFetch time.Time
+	services Units
+	// 最近一次是否成功从 systemctl 获取状态
+	stateKnown bool
+	lastFetch  time.Time
+	lock       sync.Mutex
 }
 
 func NewServer() (server.LogServer, error) {
@@ -47,19 +70,34 @@ func NewServer() (server.LogServer, error) {
 	return nil, nil
 }
 
-func (s *Server) getUnits() (Units, error) {
-	if time.Since(s.lastFetch) < 10*time.Minute {
-		slog.Debug("从缓存获取Systemd Units")
-		return s.services, nil
+// journalUnits 通过journal的字段枚举获取单元和标识符，同名时优先使用前面的字段
+func journalUnits() (Units, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, err
 	}
-	slog.Debug("更新Systemd Units")
-	s.lastFetch = time.Now()
+	defer j.Close()
 	units := Units{}
-	arg := []string{"systemctl", "list-units", "-o", "json", "--all"}
-	if len(SystemdUnitState) > 0 {
-		arg = append(arg, fmt.Sprintf("--state=%s", SystemdUnitState))
+	names := map[string]bool{}
+	for _, uf := range unitFields {
+		values, err := j.GetUniqueValues(uf.field)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range values {
+			if !names[name] {
+				names[name] = true
+				units = append(units, &Unit{Name: name, Type: unitType(name, uf.source), Source: uf.source})
+			}
+		}
 	}
-	p := exec.Command("/usr/bin/env", arg...)
+	return units, nil
+}
+
+// systemctlUnits 获取已加载单元的状态，容器等环境中没有 systemctl 时失败
+func systemctlUnits() (Units, error) {
+	units := Units{}
+	p := exec.Command("/usr/bin/env", "systemctl", "list-units", "-o", "json", "--all")
 	out, err := p.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -69,70 +107,158 @@ func (s *Server) getUnits() (Units, error) {
 		return nil, err
 	}
 	if err := json.NewDecoder(out).Decode(&units); err != nil {
+		p.Wait()
 		return nil, err
 	}
 	if err := p.Wait(); err != nil {
 		return nil, err
 	}
+	return units, nil
+}
+
+// getUnits 返回的 bool 表示单元是否有 systemctl 的状态信息
+func (s *Server) getUnits() (Units, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if time.Since(s.lastFetch) < 10*time.Minute {
+		slog.Debug("从缓存获取Systemd Units")
+		return s.services, s.stateKnown, nil
+	}
+	slog.Debug("更新Systemd Units")
+	units, err := journalUnits()
+	if err != nil {
+		return nil, false, err
+	}
+	s.lastFetch = time.Now()
+	states, err := systemctlUnits()
+	if s.stateKnown = err == nil; err != nil {
+		slog.Debug("获取Systemd Units状态失败", "err", err)
+	} else {
+		stateMap := map[string]*Unit{}
+		for _, state := range states {
+			stateMap[state.Name] = state
+		}
+		for _, unit := range units {
+			if state, ok := stateMap[unit.Name]; ok && unit.Type != UnitTypeIdentifier {
+				unit.Load, unit.Active, unit.State, unit.Description = state.Load, state.Active, state.State, state.Description
+			}
+		}
+	}
 	sort.Sort(units)
 	s.services = units
-	return s.services, nil
+	return s.services, s.stateKnown, nil
 }
 
+// HandleList 返回按类型和名称排序的单元，最后是内核日志 dmesg
 func (s *Server) HandleList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		server.HTTPError(w, http.StatusMethodNotAllowed)
 		return
 	}
-	units, err := s.getUnits()
+	units, known, err := s.getUnits()
 	if err != nil {
 		slog.Error("获取Systemd Units异常", "err", err)
 		server.HTTPError(w, http.StatusInternalServerError)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	states := ParseStates(r.URL.Query())
 	w.Header().Set("Content-Type", "application/json")
 	sep := "["
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
 	for _, unit := range units {
+		if !unit.MatchState(states, known) {
+			continue
+		}
 		fmt.Fprint(w, sep)
-		enc.Encode(unit.Name)
+		enc.Encode(unit)
 		sep = ","
 	}
 	fmt.Fprint(w, sep)
-	enc.Encode("dmesg")
+	enc.Encode(&Unit{Name: "dmesg", Source: UnitSourceKernel, Type: UnitTypeKernel, Description: "内核日志"})
 	fmt.Fprint(w, "]")
 }
 
 type Message struct {
-	TimeStamp float64 `json:"ts"`
-	Monotonic float64 `json:"monotonic"`
-	HostName  string  `json:"hostname"`
-	Process   string  `json:"process"`
-	Pid       string  `json:"pid"`
-	Message   string  `json:"message"`
-	Priority  string  `json:"priority"`
+	TimeStamp float64        `json:"ts"`
+	Monotonic float64        `json:"monotonic"`
+	HostName  string         `json:"hostname"`
+	Process   string         `json:"process"`
+	Pid       string         `json:"pid"`
+	Message   string         `json:"message"`
+	Priority  string         `json:"priority"`
+	Cursor    string         `json:"cursor"`
+	Fields    map[string]any `json:"fields,omitempty"`
+	Spans     []parser.Span  `json:"spans,omitempty"`
 }
 
-func GetHttpSystemdJournal(q url.Values) (j *sdjournal.Journal, tail uint64, until time.Time, err error) {
-	name := q.Get("name")
+func NewMessage(e *sdjournal.JournalEntry) *Message {
+	return &Message{
+		TimeStamp: float64(e.RealtimeTimestamp) / 1000,
+		Monotonic: float64(e.MonotonicTimestamp) / 1000000,
+		HostName:  e.Fields[sdjournal.SD_JOURNAL_FIELD_HOSTNAME],
+		Process:   e.Fields[sdjournal.SD_JOURNAL_FIELD_COMM],
+		Pid:       e.Fields[sdjournal.SD_JOURNAL_FIELD_PID],
+		Message:   e.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE],
+		Priority:  e.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY],
+		Cursor:    e.Cursor,
+	}
+}
+
+// Apply 解析消息内容，返回是否满足过滤条件。rd 不为 nil 时对消息和字段脱敏
+func (m *Message) Apply(pipeline *parser.Pipeline, rd parser.Redactor) bool {
+	e := &parser.Entry{
+		TimeStamp: m.TimeStamp,
+		Message:   m.Message,
+		Labels: map[string]string{
+			"hostname": m.HostName,
+			"process":  m.Process,
+			"pid":      m.Pid,
+			"priority": m.Priority,
+		},
+	}
+	ok := pipeline.Apply(e)
+	if ok && rd != nil {
+		m.Message = rd.RedactMessage(m.Message)
+		parser.RedactFields(rd, e.Fields)
+	}
+	m.Fields = e.Fields
+	return ok
+}
+
+// addMatches 各部分之间用 AddConjunction 连接，部分内的组之间用 AddDisjunction 连接
+func addMatches(j *sdjournal.Journal, matches Matches) error {
+	for idx, section := range matches {
+		if idx > 0 {
+			if err := j.AddConjunction(); err != nil {
+				return err
+			}
+		}
+		for gidx, group := range section {
+			if gidx > 0 {
+				if err := j.AddDisjunction(); err != nil {
+					return err
+				}
+			}
+			for _, m := range group {
+				if err := j.AddMatch(m); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func GetHttpSystemdJournal(q url.Values, matches Matches) (j *sdjournal.Journal, tail uint64, until time.Time, err error) {
 	j, err = sdjournal.NewJournal()
 	if err != nil {
 		return
 	}
-	if name == "kernel" || name == "dmesg" {
-		j.AddMatch((&sdjournal.Match{Field: "_TRANSPORT", Value: "kernel"}).String())
-	} else {
-		if err = j.AddMatch((&sdjournal.Match{Field: "_SYSTEMD_UNIT", Value: name}).String()); err != nil {
-			return
-		}
-		if err = j.AddDisjunction(); err != nil {
-			return
-		}
-		if err = j.AddMatch((&sdjournal.Match{Field: "UNIT", Value: name}).String()); err != nil {
-			return
-		}
+	if err = addMatches(j, matches); err != nil {
+		j.Close()
+		return
 	}
 	tail = 1000
 	until = time.Unix(253402300799, 0)
@@ -155,69 +281,125 @@ func (s *Server) HandleTail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "name"); err != nil {
+	matches, err := parseMatches(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, err := parser.FromQuery(q, &parser.Config{Type: DefaultParser})
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	j, tail, until, err := GetHttpSystemdJournal(q)
+	color, err := parser.ParseColorMode(q.Get("color"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j, tail, until, err := GetHttpSystemdJournal(q, matches)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer j.Close()
-	if tail > 0 {
-		if err = j.SeekTail(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if _, err = j.PreviousSkip(tail); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		if err = j.SeekHead(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	pq, err := ParsePageQuery(q, tail)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if _, err = j.GetCursor(); err != nil {
+	entries, err := readPage(j, pq, uint64(until.UnixMicro()))
+	if errors.Is(err, ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if len(entries) == 0 && !pq.Paging() {
 		http.Error(w, "404 Not Found", http.StatusNotFound)
 		return
 	}
-	until_ts := uint64(until.UnixMicro())
+	if len(entries) > 0 {
+		w.Header().Set(HeaderFirstCursor, entries[0].Cursor)
+		w.Header().Set(HeaderLastCursor, entries[len(entries)-1].Cursor)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	sep := "["
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
-	for {
-		e, err := j.GetEntry()
-		if err != nil {
-			panic(err)
-		}
-		if e.RealtimeTimestamp > until_ts {
-			break
+	rd := parser.GetRedactor(w)
+	for _, e := range entries {
+		if msg := NewMessage(e); msg.Apply(pipeline, rd) {
+			msg.Message, msg.Spans = color.Convert(msg.Message)
+			fmt.Fprint(w, sep)
+			enc.Encode(msg)
+			sep = ","
 		}
+	}
+	if sep == "[" {
 		fmt.Fprint(w, sep)
-		enc.Encode(&Message{
-			TimeStamp: float64(e.RealtimeTimestamp) / 1000,
-			Monotonic: float64(e.MonotonicTimestamp) / 1000000,
-			HostName:  e.Fields[sdjournal.SD_JOURNAL_FIELD_HOSTNAME],
-			Process:   e.Fields[sdjournal.SD_JOURNAL_FIELD_COMM],
-			Pid:       e.Fields[sdjournal.SD_JOURNAL_FIELD_PID],
-			Message:   e.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE],
-			Priority:  e.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY],
-		})
-		sep = ","
-		n, err := j.Next()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	}
+	fmt.Fprint(w, "]")
+}
+
+// seekCursor 定位到cursor之后(或之前)的第一条日志，cursor 已不存在时定位到最近的日志，返回是否有日志
+func seekCursor(j *sdjournal.Journal, cursor string, move func() (uint64, error)) (bool, error) {
+	if err := j.SeekCursor(cursor); err != nil {
+		return false, errors.Wrap(ErrInvalidQuery, err.Error())
+	}
+	n, err := move()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if j.TestCursor(cursor) == nil {
+		n, err = move()
+	}
+	return n > 0, err
+}
+
+// readPage 按 before、after 或 tail 定位并读取一页日志，按时间排序。Limit 为 0 时不限制条数
+func readPage(j *sdjournal.Journal, pq *PageQuery, until uint64) ([]*sdjournal.JournalEntry, error) {
+	entries := []*sdjournal.JournalEntry{}
+	full := func() bool { return pq.Limit > 0 && uint64(len(entries)) >= pq.Limit }
+	var positioned bool
+	var n uint64
+	var err error
+	switch {
+	case len(pq.Before) > 0:
+		positioned, err = seekCursor(j, pq.Before, j.Previous)
+		for positioned && err == nil && !full() {
+			var e *sdjournal.JournalEntry
+			if e, err = j.GetEntry(); err != nil {
+				break
+			}
+			entries = append(entries, e)
+			n, err = j.Previous()
+			positioned = n > 0
+		}
+		slices.Reverse(entries)
+		return entries, err
+	case len(pq.After) > 0:
+		positioned, err = seekCursor(j, pq.After, j.Next)
+	case pq.Limit > 0:
+		if err = j.SeekTail(); err == nil {
+			n, err = j.PreviousSkip(pq.Limit)
+			positioned = n > 0
 		}
-		if n <= 0 {
+	default:
+		if err = j.SeekHead(); err == nil {
+			n, err = j.Next()
+			positioned = n > 0
+		}
+	}
+	for positioned && err == nil && !full() {
+		var e *sdjournal.JournalEntry
+		if e, err = j.GetEntry(); err != nil || e.RealtimeTimestamp > until {
 			break
 		}
+		entries = append(entries, e)
+		n, err = j.Next()
+		positioned = n > 0
 	}
-	fmt.Fprint(w, "]")
+	return entries, err
 }
 
 func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
@@ -226,40 +408,62 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := r.URL.Query()
-	if err := server.EnsureKeys(q, "name"); err != nil {
+	matches, err := parseMatches(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, err := parser.FromQuery(q, &parser.Config{Type: DefaultParser})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	color, err := parser.ParseColorMode(q.Get("color"))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	j, tail, until, err := GetHttpSystemdJournal(q)
+	j, tail, until, err := GetHttpSystemdJournal(q, matches)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer j.Close()
 
-	if tail > 0 {
-		if err = j.SeekTail(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	var cur string
+	if q.Has("after") {
+		// 从上次收到的cursor继续监听，cursor本身已发送过
+		cur = q.Get("after")
+		if err = j.SeekCursor(cur); err == nil {
+			_, err = j.Next()
 		}
-		if _, err = j.PreviousSkip(tail); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 	} else {
-		if err = j.SeekHead(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if tail > 0 {
+			if err = j.SeekTail(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if _, err = j.PreviousSkip(tail); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			if err = j.SeekHead(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if cur, err = j.GetCursor(); err != nil {
+			fmt.Println("GetCursor error:", err)
+			http.Error(w, "404 Not Found", http.StatusNotFound)
 			return
 		}
 	}
 
-	var cur string
-	if cur, err = j.GetCursor(); err != nil {
-		fmt.Println("GetCursor error:", err)
-		http.Error(w, "404 Not Found", http.StatusNotFound)
-		return
-	}
-
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.NotFound(w, r)
@@ -272,6 +476,7 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
+	rd := parser.GetRedactor(w)
 	until_ts := uint64(until.UnixMicro())
 
 	for {
@@ -291,21 +496,16 @@ func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
 					slog.Debug("监听停止", "reason", "到达时间期限")
 					return
 				}
-				fmt.Fprint(w, "data: ")
-				if err = enc.Encode(&Message{
-					TimeStamp: float64(e.RealtimeTimestamp) / 1000,
-					Monotonic: float64(e.MonotonicTimestamp) / 1000000,
-					HostName:  e.Fields[sdjournal.SD_JOURNAL_FIELD_HOSTNAME],
-					Process:   e.Fields[sdjournal.SD_JOURNAL_FIELD_COMM],
-					Pid:       e.Fields[sdjournal.SD_JOURNAL_FIELD_PID],
-					Message:   e.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE],
-					Priority:  e.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY],
-				}); err != nil {
-					slog.Debug("监听停止", "reason", "Json序列化异常", "err", err)
-					return
+				if msg := NewMessage(e); msg.Apply(pipeline, rd) {
+					msg.Message, msg.Spans = color.Convert(msg.Message)
+					fmt.Fprint(w, "data: ")
+					if err = enc.Encode(msg); err != nil {
+						slog.Debug("监听停止", "reason", "Json序列化异常", "err", err)
+						return
+					}
+					fmt.Fprint(w, "\n")
+					flusher.Flush()
 				}
-				fmt.Fprint(w, "\n")
-				flusher.Flush()
 			}
 			if n, err := j.Next(); err != nil {
 				slog.Debug("监听停止", "reason", "获取下一条Entry异常", "err", err)
@@ -0,0 +1,249 @@
+package parser
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type ColorMode string
+
+const (
+	// ColorKeep 原样输出控制字符
+	ColorKeep ColorMode = "keep"
+	// ColorStrip 删除所有ANSI控制序列
+	ColorStrip ColorMode = "strip"
+	// ColorHTML 转换为带 class/style 的 <span>，文本经过HTML转义
+	ColorHTML ColorMode = "html"
+	// ColorSpans 消息去掉控制序列，样式放到 spans 字段
+	ColorSpans ColorMode = "spans"
+)
+
+var (
+	ErrUnsupportColor = errors.New("unsupported color mode")
+
+	// CSI、OSC 以及其他两字节转义序列
+	ansiRegex = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+	ansiColorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+)
+
+func ParseColorMode(s string) (ColorMode, error) {
+	switch mode := ColorMode(s); mode {
+	case "":
+		return ColorKeep, nil
+	case ColorKeep, ColorStrip, ColorHTML, ColorSpans:
+		return mode, nil
+	default:
+		return "", errors.Wrap(ErrUnsupportColor, s)
+	}
+}
+
+// Style 颜色为 red/bright-red 这类16色名称或 #rrggbb
+type Style struct {
+	Fg        string `json:"fg,omitempty"`
+	Bg        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Dim       bool   `json:"dim,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Inverse   bool   `json:"inverse,omitempty"`
+	Strike    bool   `json:"strike,omitempty"`
+}
+
+type Span struct {
+	Text string `json:"text"`
+	Style
+}
+
+func StripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiRegex.ReplaceAllString(s, "")
+}
+
+// ParseANSI 按SGR序列把文本切分为样式片段，非SGR的控制序列直接丢弃
+func ParseANSI(s string) []Span {
+	spans := []Span{}
+	style := Style{}
+	last := 0
+	for _, loc := range ansiRegex.FindAllStringIndex(s, -1) {
+		if loc[0] > last {
+			spans = append(spans, Span{Text: s[last:loc[0]], Style: style})
+		}
+		last = loc[1]
+		if seq := s[loc[0]:loc[1]]; strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") {
+			style.apply(seq[2 : len(seq)-1])
+		}
+	}
+	if last < len(s) {
+		spans = append(spans, Span{Text: s[last:], Style: style})
+	}
+	return spans
+}
+
+func (st *Style) apply(params string) {
+	args := strings.Split(params, ";")
+	for i := 0; i < len(args); i++ {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			// 空参数等同于 0
+			n = 0
+		}
+		switch {
+		case n == 0:
+			*st = Style{}
+		case n == 1:
+			st.Bold = true
+		case n == 2:
+			st.Dim = true
+		case n == 3:
+			st.Italic = true
+		case n == 4:
+			st.Underline = true
+		case n == 7:
+			st.Inverse = true
+		case n == 9:
+			st.Strike = true
+		case n == 22:
+			st.Bold, st.Dim = false, false
+		case n == 23:
+			st.Italic = false
+		case n == 24:
+			st.Underline = false
+		case n == 27:
+			st.Inverse = false
+		case n == 29:
+			st.Strike = false
+		case n >= 30 && n <= 37:
+			st.Fg = ansiColorNames[n-30]
+		case n == 38:
+			st.Fg, i = extendedColor(args, i)
+		case n == 39:
+			st.Fg = ""
+		case n >= 40 && n <= 47:
+			st.Bg = ansiColorNames[n-40]
+		case n == 48:
+			st.Bg, i = extendedColor(args, i)
+		case n == 49:
+			st.Bg = ""
+		case n >= 90 && n <= 97:
+			st.Fg = "bright-" + ansiColorNames[n-90]
+		case n >= 100 && n <= 107:
+			st.Bg = "bright-" + ansiColorNames[n-100]
+		}
+	}
+}
+
+// extendedColor 解析 38;5;n 和 38;2;r;g;b，返回颜色和最后一个已消费参数的下标
+func extendedColor(args []string, i int) (string, int) {
+	if i+1 >= len(args) {
+		return "", i
+	}
+	num := func(j int) int {
+		n, _ := strconv.Atoi(args[j])
+		return min(max(n, 0), 255)
+	}
+	switch args[i+1] {
+	case "5":
+		if i+2 >= len(args) {
+			return "", len(args)
+		}
+		return color256(num(i + 2)), i + 2
+	case "2":
+		if i+4 >= len(args) {
+			return "", len(args)
+		}
+		return fmt.Sprintf("#%02x%02x%02x", num(i+2), num(i+3), num(i+4)), i + 4
+	}
+	return "", i + 1
+}
+
+func color256(n int) string {
+	switch {
+	case n < 8:
+		return ansiColorNames[n]
+	case n < 16:
+		return "bright-" + ansiColorNames[n-8]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+}
+
+func cssColor(kind, prop, color string, class, style *[]string) {
+	if strings.HasPrefix(color, "#") {
+		*style = append(*style, prop+":"+color)
+	} else {
+		*class = append(*class, "ansi-"+kind+"-"+color)
+	}
+}
+
+// HTML 16色输出为 ansi-fg-red/ansi-bg-red 这类 class，由前端主题决定具体颜色
+func (sp Span) HTML() string {
+	text := html.EscapeString(sp.Text)
+	if sp.Style == (Style{}) {
+		return text
+	}
+	class, style := []string{}, []string{}
+	if len(sp.Fg) > 0 {
+		cssColor("fg", "color", sp.Fg, &class, &style)
+	}
+	if len(sp.Bg) > 0 {
+		cssColor("bg", "background-color", sp.Bg, &class, &style)
+	}
+	for _, attr := range []struct {
+		name string
+		on   bool
+	}{
+		{"bold", sp.Bold}, {"dim", sp.Dim}, {"italic", sp.Italic},
+		{"underline", sp.Underline}, {"inverse", sp.Inverse}, {"strike", sp.Strike},
+	} {
+		if attr.on {
+			class = append(class, "ansi-"+attr.name)
+		}
+	}
+	var buf strings.Builder
+	buf.WriteString("<span")
+	if len(class) > 0 {
+		fmt.Fprintf(&buf, ` class="%s"`, strings.Join(class, " "))
+	}
+	if len(style) > 0 {
+		fmt.Fprintf(&buf, ` style="%s"`, strings.Join(style, ";"))
+	}
+	buf.WriteString(">")
+	buf.WriteString(text)
+	buf.WriteString("</span>")
+	return buf.String()
+}
+
+// Convert 按模式转换消息，ColorSpans 时额外返回样式片段
+func (mode ColorMode) Convert(msg string) (string, []Span) {
+	switch mode {
+	case ColorStrip:
+		return StripANSI(msg), nil
+	case ColorHTML:
+		var buf strings.Builder
+		for _, sp := range ParseANSI(msg) {
+			buf.WriteString(sp.HTML())
+		}
+		return buf.String(), nil
+	case ColorSpans:
+		return StripANSI(msg), ParseANSI(msg)
+	}
+	return msg, nil
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"strings"
+	"time"
+)
+
+// CRILine CRI格式的一行：<RFC3339Nano> <stdout|stderr> <P|F> <msg>，P 表示部分行
+type CRILine struct {
+	Time     time.Time
+	TimeText string
+	Stream   string
+	Tag      string
+	Message  string
+}
+
+// ParseCRILine 拆分字段并解析时间，格式不符时返回 false
+func ParseCRILine(line string) (CRILine, bool) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return CRILine{}, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return CRILine{}, false
+	}
+	cl := CRILine{Time: ts, TimeText: fields[0], Stream: fields[1], Tag: fields[2]}
+	if len(fields) == 4 {
+		cl.Message = fields[3]
+	}
+	return cl, true
+}
+
+// criParser 解析单行CRI格式
+type criParser struct{}
+
+func (criParser) Parse(e *Entry) {
+	cl, ok := ParseCRILine(e.Message)
+	if !ok {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	e.Fields["time"] = cl.TimeText
+	e.Fields["stream"] = cl.Stream
+	e.Fields["tag"] = cl.Tag
+	if e.TimeStamp == 0 {
+		e.TimeStamp = float64(cl.Time.UnixNano()) / 1e6
+	}
+	if len(e.Stream) == 0 {
+		e.Stream = cl.Stream
+	}
+	e.Fields["message"] = cl.Message
+}
@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type jsonParser struct{}
+
+func (jsonParser) Parse(e *Entry) {
+	if !strings.HasPrefix(strings.TrimSpace(e.Message), "{") {
+		return
+	}
+	dec := json.NewDecoder(strings.NewReader(e.Message))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	flatten(e.Fields, "", m)
+}
+
+// flatten 嵌套对象展开为 a.b 形式的字段
+func flatten(dst map[string]any, prefix string, m map[string]any) {
+	for key, val := range m {
+		if len(prefix) > 0 {
+			key = prefix + "." + key
+		}
+		if sub, ok := val.(map[string]any); ok {
+			flatten(dst, key, sub)
+		} else {
+			dst[key] = val
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+type logfmtParser struct{}
+
+// Parse 解析 key=value key2="quoted value" 形式的日志，没有值的 key 为空字符串
+func (logfmtParser) Parse(e *Entry) {
+	fields := map[string]any{}
+	s := e.Message
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if len(s) == 0 {
+			break
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			fields[s] = ""
+			break
+		}
+		key := s[:end]
+		s = s[end:]
+		if !strings.HasPrefix(s, "=") {
+			if len(key) > 0 {
+				fields[key] = ""
+			}
+			continue
+		}
+		s = s[1:]
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				// 引号未闭合，剩余部分都作为值
+				val, s = s[1:], ""
+			} else {
+				s = s[len(quoted):]
+				if val, err = strconv.Unquote(quoted); err != nil {
+					val = quoted[1 : len(quoted)-1]
+				}
+			}
+		} else {
+			end = strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			val, s = s[:end], s[end:]
+		}
+		if len(key) > 0 {
+			fields[key] = val
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = fields
+		return
+	}
+	for key, val := range fields {
+		e.Fields[key] = val
+	}
+}
@@ -0,0 +1,234 @@
+package parser
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultMultilineTimeout  = time.Second
+	DefaultMultilineMaxLines = 1000
+)
+
+var ErrInvalidMultiline = errors.New("invalid multiline config")
+
+// Multiline 多行合并规则，Start 匹配新事件的开头，Continue 匹配续行，二选一
+type Multiline struct {
+	Start    *regexp.Regexp
+	Continue *regexp.Regexp
+	// 实时监听时，事件超过该时间没有新行就直接输出
+	Timeout  time.Duration
+	MaxLines int
+}
+
+type multilineConf struct {
+	Start    string `json:"start" yaml:"start"`
+	Continue string `json:"continue" yaml:"continue"`
+	Timeout  string `json:"timeout" yaml:"timeout"`
+	MaxLines int    `json:"max_lines" yaml:"max_lines"`
+}
+
+func (c *multilineConf) compile() (*Multiline, error) {
+	if (len(c.Start) > 0) == (len(c.Continue) > 0) {
+		return nil, errors.Wrap(ErrInvalidMultiline, "one of start and continue is required")
+	}
+	m := &Multiline{Timeout: DefaultMultilineTimeout, MaxLines: DefaultMultilineMaxLines}
+	var err error
+	if len(c.Start) > 0 {
+		if m.Start, err = regexp.Compile(c.Start); err != nil {
+			return nil, err
+		}
+	} else if m.Continue, err = regexp.Compile(c.Continue); err != nil {
+		return nil, err
+	}
+	if len(c.Timeout) > 0 {
+		if m.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxLines > 0 {
+		m.MaxLines = c.MaxLines
+	}
+	return m, nil
+}
+
+func (m *Multiline) UnmarshalJSON(data []byte) error {
+	c := multilineConf{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	res, err := c.compile()
+	if err != nil {
+		return err
+	}
+	*m = *res
+	return nil
+}
+
+func (m *Multiline) UnmarshalYAML(value *yaml.Node) error {
+	c := multilineConf{}
+	if err := value.Decode(&c); err != nil {
+		return err
+	}
+	res, err := c.compile()
+	if err != nil {
+		return err
+	}
+	*m = *res
+	return nil
+}
+
+// NewMultiline start 和 cont 都为空时返回 nil
+func NewMultiline(start, cont string) (*Multiline, error) {
+	if len(start) == 0 && len(cont) == 0 {
+		return nil, nil
+	}
+	return (&multilineConf{Start: start, Continue: cont}).compile()
+}
+
+// MultilineFromQuery 请求参数 multiline_start/multiline_continue 覆盖默认规则，multiline=false 关闭合并
+func MultilineFromQuery(q url.Values, def *Multiline) (*Multiline, error) {
+	if q.Get("multiline") == "false" {
+		return nil, nil
+	}
+	if !q.Has("multiline_start") && !q.Has("multiline_continue") {
+		return def, nil
+	}
+	c := multilineConf{
+		Start:    q.Get("multiline_start"),
+		Continue: q.Get("multiline_continue"),
+		Timeout:  q.Get("multiline_timeout"),
+	}
+	if q.Has("multiline_max_lines") {
+		val, err := strconv.Atoi(q.Get("multiline_max_lines"))
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidMultiline, err.Error())
+		}
+		c.MaxLines = val
+	}
+	return c.compile()
+}
+
+func (m *Multiline) isStart(msg string) bool {
+	if m.Start != nil {
+		return m.Start.MatchString(msg)
+	}
+	return !m.Continue.MatchString(msg)
+}
+
+type pendingEvent struct {
+	entry   *Entry
+	buf     strings.Builder
+	lines   int
+	updated time.Time
+}
+
+func (p *pendingEvent) done() *Entry {
+	p.entry.Message = p.buf.String()
+	return p.entry
+}
+
+// Grouper 按多行规则把连续的行合并为一个事件，不同输出流分开合并
+type Grouper struct {
+	conf    *Multiline
+	pending map[string]*pendingEvent
+}
+
+// NewGrouper 规则为 nil 时返回 nil，nil 的 Grouper 不做合并
+func (m *Multiline) NewGrouper() *Grouper {
+	if m == nil {
+		return nil
+	}
+	return &Grouper{conf: m, pending: map[string]*pendingEvent{}}
+}
+
+// Add 加入一行，返回因此完成的事件，没有完成的事件时返回 nil
+func (g *Grouper) Add(e *Entry) *Entry {
+	if g == nil {
+		return e
+	}
+	p, ok := g.pending[e.Stream]
+	var done *Entry
+	if ok && g.conf.isStart(e.Message) {
+		done = p.done()
+		ok = false
+	}
+	if !ok {
+		p = &pendingEvent{entry: e}
+		p.buf.WriteString(e.Message)
+		g.pending[e.Stream] = p
+	} else {
+		p.buf.WriteByte('\n')
+		p.buf.WriteString(e.Message)
+	}
+	p.lines++
+	p.updated = time.Now()
+	if done == nil && p.lines >= g.conf.MaxLines {
+		delete(g.pending, e.Stream)
+		done = p.done()
+	}
+	return done
+}
+
+// Flush 输出所有未完成的事件
+func (g *Grouper) Flush() []*Entry {
+	return g.flush(0)
+}
+
+// FlushStale 输出超过 Timeout 没有新行的事件
+func (g *Grouper) FlushStale() []*Entry {
+	if g == nil {
+		return nil
+	}
+	return g.flush(g.conf.Timeout)
+}
+
+func (g *Grouper) flush(age time.Duration) []*Entry {
+	if g == nil {
+		return nil
+	}
+	entries := []*Entry{}
+	for stream, p := range g.pending {
+		if time.Since(p.updated) >= age {
+			delete(g.pending, stream)
+			entries = append(entries, p.done())
+		}
+	}
+	return entries
+}
+
+// Timeout 实时监听时检查超时的间隔
+func (g *Grouper) Timeout() time.Duration {
+	if g == nil {
+		return 0
+	}
+	return g.conf.Timeout
+}
+
+// EventCounter 从文件中间开始读取时，每个输出流的第一个事件可能是不完整的，
+// Complete 为这些事件之后的事件数
+type EventCounter struct {
+	Count    int64
+	Complete int64
+	streams  map[string]bool
+}
+
+func (c *EventCounter) Add(e *Entry) {
+	if c.streams == nil {
+		c.streams = map[string]bool{}
+	}
+	c.Count++
+	if !c.streams[e.Stream] {
+		c.streams[e.Stream] = true
+		c.Complete = 0
+	} else {
+		c.Complete++
+	}
+}
@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/boringcat/just-a-log-viewer/server"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TypeNone   = "none"
+	TypeJSON   = "json"
+	TypeLogfmt = "logfmt"
+	TypeRegex  = "regex"
+	TypeCRI    = "cri"
+	TypeSyslog = "syslog"
+)
+
+var (
+	ErrUnsupportParser = errors.New("unsupported parser")
+	// SupportedTypes 不需要额外配置的解析器
+	SupportedTypes = []string{TypeNone, TypeJSON, TypeLogfmt, TypeCRI, TypeSyslog}
+)
+
+// Entry 一条日志，Fields 为解析器提取出的结构化字段
+type Entry struct {
+	TimeStamp float64           `json:"ts,omitempty"`
+	Stream    string            `json:"stream,omitempty"`
+	Message   string            `json:"message"`
+	Fields    map[string]any    `json:"fields,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Spans     []Span            `json:"spans,omitempty"`
+}
+
+// Field 获取字段值用于过滤，message 和 stream 对应日志本身，其次是解析出的字段和标签
+func (e *Entry) Field(key string) string {
+	switch key {
+	case "message":
+		return e.Message
+	case "stream":
+		return e.Stream
+	}
+	if val, ok := e.Fields[key]; ok {
+		return fmt.Sprint(val)
+	}
+	return e.Labels[key]
+}
+
+// Parser 从日志消息中提取字段
+type Parser interface {
+	Parse(e *Entry)
+}
+
+// Config 解析器配置，可以直接写类型名，也可以写成 {type: regex, regex: ...}
+type Config struct {
+	Type  string         `json:"type" yaml:"type"`
+	Regex *regexp.Regexp `json:"regex" yaml:"regex"`
+}
+
+func (c *Config) fromMap(m map[string]string) (err error) {
+	c.Type = m["type"]
+	if len(m["regex"]) > 0 {
+		c.Regex, err = regexp.Compile(m["regex"])
+	}
+	return
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Type); err == nil {
+		return nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err == nil {
+		return c.fromMap(m)
+	}
+	return errors.Wrap(ErrUnsupportParser, "parser config")
+}
+
+func (c *Config) UnmarshalYAML(value *yaml.Node) error {
+	if err := value.Decode(&c.Type); err == nil {
+		return nil
+	}
+	var m map[string]string
+	if err := value.Decode(&m); err == nil {
+		return c.fromMap(m)
+	}
+	return errors.Wrap(ErrUnsupportParser, "parser config")
+}
+
+// New 根据配置创建解析器，未配置时返回 nil
+func New(c *Config) (Parser, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case "", TypeNone:
+		return nil, nil
+	case TypeJSON:
+		return jsonParser{}, nil
+	case TypeLogfmt:
+		return logfmtParser{}, nil
+	case TypeRegex:
+		if c.Regex == nil {
+			return nil, errors.Wrap(ErrUnsupportParser, "regex parser without regex")
+		}
+		return regexParser{c.Regex}, nil
+	case TypeCRI:
+		return criParser{}, nil
+	case TypeSyslog:
+		return syslogParser{}, nil
+	}
+	return nil, errors.Wrap(ErrUnsupportParser, c.Type)
+}
+
+// Pipeline 解析日志并按字段过滤
+type Pipeline struct {
+	Parser Parser
+	Filter server.Selector
+}
+
+// FromQuery 根据请求参数创建，parser(及 parser_regex)覆盖默认配置，filter 为字段选择器
+func FromQuery(q url.Values, def *Config) (*Pipeline, error) {
+	conf := def
+	if q.Has("parser") {
+		conf = &Config{Type: q.Get("parser")}
+		if q.Has("parser_regex") {
+			if err := conf.fromMap(map[string]string{"type": conf.Type, "regex": q.Get("parser_regex")}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	p := &Pipeline{}
+	var err error
+	if p.Parser, err = New(conf); err != nil {
+		return nil, err
+	}
+	if q.Has("filter") {
+		if p.Filter, err = server.ParseSelector(q.Get("filter")); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Apply 解析日志，返回是否满足过滤条件
+func (p *Pipeline) Apply(e *Entry) bool {
+	if p.Parser != nil {
+		p.Parser.Parse(e)
+	}
+	return p.Filter.MatchesFunc(e.Field)
+}
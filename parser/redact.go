@@ -0,0 +1,44 @@
+package parser
+
+import "io"
+
+// Redactor 由 http.ResponseWriter 实现时，在编码前对日志内容脱敏
+type Redactor interface {
+	RedactMessage(string) string
+}
+
+// GetRedactor w 没有实现 Redactor 时返回 nil
+func GetRedactor(w io.Writer) Redactor {
+	rd, _ := w.(Redactor)
+	return rd
+}
+
+// RedactFields 对解析出的字段中的字符串脱敏，包括嵌套的对象和数组
+func RedactFields(rd Redactor, fields map[string]any) {
+	for k, v := range fields {
+		fields[k] = redactValue(rd, v)
+	}
+}
+
+func redactValue(rd Redactor, v any) any {
+	switch val := v.(type) {
+	case string:
+		return rd.RedactMessage(val)
+	case map[string]any:
+		RedactFields(rd, val)
+	case []any:
+		for idx := range val {
+			val[idx] = redactValue(rd, val[idx])
+		}
+	}
+	return v
+}
+
+// Redact 对日志的消息和字段脱敏，rd 为 nil 时不处理
+func (e *Entry) Redact(rd Redactor) {
+	if rd == nil {
+		return
+	}
+	e.Message = rd.RedactMessage(e.Message)
+	RedactFields(rd, e.Fields)
+}
@@ -0,0 +1,23 @@
+package parser
+
+import "regexp"
+
+// regexParser 正则的命名分组作为字段
+type regexParser struct {
+	re *regexp.Regexp
+}
+
+func (p regexParser) Parse(e *Entry) {
+	match := p.re.FindStringSubmatch(e.Message)
+	if match == nil {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = map[string]any{}
+	}
+	for idx, name := range p.re.SubexpNames() {
+		if len(name) > 0 {
+			e.Fields[name] = match[idx]
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var (
+	// RFC5424: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+	syslog5424 = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]"]|"(?:[^"\\]|\\.)*")*\])+) ?(.*)$`)
+	// RFC3164 及 rsyslog 落盘格式，PRI 可省略：<PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+	syslog3164 = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+) (\S+) ([^:\[\s]+)(?:\[(\d+)\])?: ?(.*)$`)
+	severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+)
+
+type syslogParser struct{}
+
+func (syslogParser) Parse(e *Entry) {
+	var fields map[string]any
+	if m := syslog5424.FindStringSubmatch(e.Message); m != nil {
+		fields = map[string]any{
+			"timestamp": m[2],
+			"hostname":  m[3],
+			"appname":   m[4],
+			"procid":    m[5],
+			"msgid":     m[6],
+			"sd":        m[7],
+			"message":   m[8],
+		}
+		setPriority(fields, m[1])
+	} else if m := syslog3164.FindStringSubmatch(e.Message); m != nil {
+		fields = map[string]any{
+			"timestamp": m[2],
+			"hostname":  m[3],
+			"appname":   m[4],
+			"procid":    m[5],
+			"message":   m[6],
+		}
+		setPriority(fields, m[1])
+	} else {
+		return
+	}
+	if e.Fields == nil {
+		e.Fields = fields
+		return
+	}
+	for key, val := range fields {
+		e.Fields[key] = val
+	}
+}
+
+func setPriority(fields map[string]any, pri string) {
+	if len(pri) == 0 {
+		return
+	}
+	val, err := strconv.Atoi(pri)
+	if err != nil || val > 191 {
+		return
+	}
+	fields["facility"] = val / 8
+	fields["severity"] = val % 8
+	fields["level"] = severities[val%8]
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnsupportOutput = errors.New("unsupported output format")
+
+// Writer 按输出格式写入日志，ndjson 为每行一个JSON对象
+type Writer struct {
+	w      io.Writer
+	enc    *json.Encoder
+	NDJSON bool
+	Color  ColorMode
+	// w 实现了 Redactor 时输出前脱敏
+	redactor Redactor
+}
+
+// NewWriter format 为 text(默认) 或 ndjson，color 见 ParseColorMode
+func NewWriter(w io.Writer, format, color string) (*Writer, error) {
+	mode, err := ParseColorMode(color)
+	if err != nil {
+		return nil, err
+	}
+	ew := &Writer{w: w, enc: json.NewEncoder(w), Color: mode, redactor: GetRedactor(w)}
+	ew.enc.SetEscapeHTML(false)
+	switch format {
+	case "", "text":
+	case "ndjson":
+		ew.NDJSON = true
+	default:
+		return nil, errors.Wrap(ErrUnsupportOutput, format)
+	}
+	return ew, nil
+}
+
+func (ew *Writer) ContentType() string {
+	if ew.NDJSON {
+		return "application/x-ndjson"
+	}
+	if ew.Color == ColorHTML {
+		return "text/html; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Raw 是否可以不经转换直接输出原始内容
+func (ew *Writer) Raw() bool {
+	return !ew.NDJSON && ew.Color == ColorKeep && ew.redactor == nil
+}
+
+// prepare 先脱敏再转换颜色
+func (ew *Writer) prepare(e *Entry) {
+	e.Redact(ew.redactor)
+	if ew.Color != ColorKeep {
+		e.Message, e.Spans = ew.Color.Convert(e.Message)
+	}
+}
+
+// text 文本格式下 spans 模式每条日志输出为样式片段的JSON数组，prefix 作为第一个片段。
+// 有 stream 时加上 [stdout] 或 [stderr] 前缀，html 模式下 prefix 需要转义
+func (ew *Writer) text(prefix string, e *Entry) (string, error) {
+	if len(e.Stream) > 0 {
+		prefix += "[" + e.Stream + "] "
+	}
+	if ew.Color == ColorHTML {
+		prefix = html.EscapeString(prefix)
+	}
+	if ew.Color != ColorSpans {
+		return prefix + e.Message, nil
+	}
+	spans := e.Spans
+	if len(prefix) > 0 {
+		spans = append([]Span{{Text: prefix}}, spans...)
+	}
+	buf, err := json.Marshal(spans)
+	return string(buf), err
+}
+
+func (ew *Writer) Write(e *Entry) error {
+	ew.prepare(e)
+	if ew.NDJSON {
+		return ew.enc.Encode(e)
+	}
+	text, err := ew.text("", e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(ew.w, text)
+	return err
+}
+
+// WriteEvent 以SSE格式写入，文本格式下 prefix 会加在消息前面，多行消息的每一行都有 data: 前缀
+func (ew *Writer) WriteEvent(prefix string, e *Entry) error {
+	ew.prepare(e)
+	fmt.Fprint(ew.w, "data: ")
+	if ew.NDJSON {
+		if err := ew.enc.Encode(e); err != nil {
+			return err
+		}
+	} else {
+		text, err := ew.text(prefix, e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(ew.w, strings.ReplaceAll(text, "\n", "\ndata: "), "\n")
+	}
+	_, err := fmt.Fprint(ew.w, "\n")
+	return err
+}
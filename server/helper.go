@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 const API_VERSION = 1
@@ -12,6 +16,10 @@ const API_VERSION = 1
 var (
 	GlobalBufSize int = 16384
 	enableFutures     = []string{}
+
+	ErrInvalidTime = errors.New("invalid time")
+	ErrMissingKeys = errors.New("missing query fields")
+	timeLayouts    = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
 )
 
 func HTTPError(w http.ResponseWriter, code int) {
@@ -22,18 +30,24 @@ func HTTPError(w http.ResponseWriter, code int) {
 	)
 }
 
+// MissingKeys 返回包装了 ErrMissingKeys 的错误
+func MissingKeys(keys ...string) error {
+	quoted := make([]string, len(keys))
+	for idx, key := range keys {
+		quoted[idx] = strconv.Quote(key)
+	}
+	return fmt.Errorf("%w: [%s]", ErrMissingKeys, strings.Join(quoted, ","))
+}
+
 func EnsureKeys(q url.Values, keys ...string) error {
-	missing := strings.Builder{}
-	sep := ""
+	missing := []string{}
 	for _, key := range keys {
-		if q.Has(key) {
-			continue
+		if !q.Has(key) {
+			missing = append(missing, key)
 		}
-		fmt.Fprintf(&missing, "%q%s", key, sep)
-		sep = ","
 	}
-	if len(sep) > 0 {
-		return fmt.Errorf("missing query fields: [%s]", missing.String())
+	if len(missing) > 0 {
+		return MissingKeys(missing...)
 	}
 	return nil
 }
@@ -47,3 +61,19 @@ func RedirectPrefix(prefix string) http.HandlerFunc {
 		http.NotFound(w, r)
 	}
 }
+
+// ParseTime 解析时间参数，支持毫秒时间戳、RFC3339、本地时间和相对于 now 的时长(如 15m 表示15分钟前)
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d.Abs()), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Wrap(ErrInvalidTime, s)
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// RedactCountHeader 非流式响应通过 Trailer 返回脱敏次数，SSE通过 redactions 事件返回累计次数
+	RedactCountHeader = "X-Log-Redactions"
+)
+
+type RedactRule struct {
+	Name  string
+	Regex *regexp.Regexp
+	// 替换模板，支持 $1 引用分组，为空时替换为 [REDACTED:Name]
+	Repl string
+	// 匹配后二次校验，返回 false 时不替换
+	Validate func(string) bool
+}
+
+var (
+	RedactPresets = map[string]*RedactRule{
+		"jwt": {
+			Regex: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+		},
+		"bearer": {
+			Regex: regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/-]+=*`),
+			Repl:  "${1}[REDACTED:bearer]",
+		},
+		"aws": {
+			Regex: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i)(aws_secret_access_key\s*[=:]\s*)[A-Za-z0-9/+=]{40}`),
+		},
+		"basic-auth": {
+			Regex: regexp.MustCompile(`\b([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s:@"]*:[^/\s@"]+@`),
+			Repl:  "${1}[REDACTED:basic-auth]@",
+		},
+		"email": {
+			Regex: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		},
+		"card": {
+			Regex:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+			Validate: isCardNumber,
+		},
+	}
+	SupportedRedactPresets = []string{"jwt", "bearer", "aws", "basic-auth", "email", "card"}
+)
+
+func init() {
+	for name, rule := range RedactPresets {
+		rule.Name = name
+	}
+}
+
+// cardBrands 发卡机构号段和卡号长度，避免把时间戳、数字ID等误判为卡号
+var cardBrands = []struct {
+	prefixes [][2]int
+	lengths  []int
+}{
+	// Visa
+	{[][2]int{{4, 4}}, []int{16, 19}},
+	// Mastercard
+	{[][2]int{{51, 55}, {2221, 2720}}, []int{16}},
+	// American Express
+	{[][2]int{{34, 34}, {37, 37}}, []int{15}},
+	// Discover
+	{[][2]int{{6011, 6011}, {644, 649}, {65, 65}}, []int{16, 19}},
+	// JCB
+	{[][2]int{{3528, 3589}}, []int{16, 19}},
+	// Diners Club
+	{[][2]int{{300, 305}, {36, 36}, {38, 39}}, []int{14}},
+	// UnionPay
+	{[][2]int{{62, 62}}, []int{16, 19}},
+}
+
+// isCardNumber 号段、长度和 Luhn 校验都满足时才认为是卡号
+func isCardNumber(s string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+	for _, brand := range cardBrands {
+		if !slices.Contains(brand.lengths, len(digits)) {
+			continue
+		}
+		for _, r := range brand.prefixes {
+			width := len(strconv.Itoa(r[0]))
+			if prefix, err := strconv.Atoi(digits[:width]); err == nil && prefix >= r[0] && prefix <= r[1] {
+				return luhn(digits)
+			}
+		}
+	}
+	return false
+}
+
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if !unicode.IsDigit(rune(s[i])) {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+type RedactOpts struct {
+	Presets []string
+	// 自定义规则，名称 -> 正则
+	Rules map[string]string
+	// 这些组的用户看到原始日志
+	ExemptGroups []string
+	// 反向代理传入用户组的请求头，多个组用逗号分隔
+	GroupHeader string
+	// 只信任来自这些地址(CIDR)的用户组请求头
+	TrustedProxies []string
+	rules          []*RedactRule
+	trusted        []netip.Prefix
+}
+
+func (o *RedactOpts) Verify() error {
+	o.rules = []*RedactRule{}
+	for _, name := range o.Presets {
+		rule, ok := RedactPresets[name]
+		if !ok {
+			return fmt.Errorf("unknown redact preset: %q", name)
+		}
+		o.rules = append(o.rules, rule)
+	}
+	for name, expr := range o.Rules {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("redact rule %q: %w", name, err)
+		}
+		o.rules = append(o.rules, &RedactRule{Name: name, Regex: re})
+	}
+	o.trusted = []netip.Prefix{}
+	for _, cidr := range o.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("redact trusted proxy %q: %w", cidr, err)
+		}
+		o.trusted = append(o.trusted, prefix)
+	}
+	// 任何客户端都可以设置用户组请求头，必须限定来源
+	if len(o.ExemptGroups) > 0 && len(o.trusted) == 0 {
+		return fmt.Errorf("redact exempt groups require trusted proxies")
+	}
+	return nil
+}
+
+func (o *RedactOpts) Enabled() bool {
+	return len(o.rules) > 0
+}
+
+// Redact 返回脱敏后的内容和替换次数
+func (o *RedactOpts) Redact(s string) (string, int) {
+	count := 0
+	for _, rule := range o.rules {
+		repl := rule.Repl
+		if len(repl) == 0 {
+			repl = fmt.Sprintf("[REDACTED:%s]", rule.Name)
+		}
+		s = rule.Regex.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Validate != nil && !rule.Validate(match) {
+				return match
+			}
+			count++
+			submatch := rule.Regex.FindStringSubmatchIndex(match)
+			return string(rule.Regex.ExpandString(nil, repl, match, submatch))
+		})
+	}
+	return s, count
+}
+
+// isExempt 只有请求来自可信的反向代理时才读取用户组请求头
+func (o *RedactOpts) isExempt(r *http.Request) bool {
+	if len(o.ExemptGroups) == 0 || len(o.GroupHeader) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !slices.ContainsFunc(o.trusted, func(p netip.Prefix) bool { return p.Contains(addr.Addr().Unmap()) }) {
+		return false
+	}
+	for _, group := range strings.Split(r.Header.Get(o.GroupHeader), ",") {
+		if slices.Contains(o.ExemptGroups, strings.TrimSpace(group)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactResponseWriter 实现 parser.Redactor，各模块在编码前对日志内容脱敏。
+// 响应直接输出，非SSE响应的脱敏次数在结束时通过 Trailer 返回
+type redactResponseWriter struct {
+	http.ResponseWriter
+	opt         *RedactOpts
+	count       int
+	reported    int
+	wroteHeader bool
+	streaming   bool
+}
+
+func (w *redactResponseWriter) RedactMessage(s string) string {
+	out, n := w.opt.Redact(s)
+	w.count += n
+	return out
+}
+
+// WriteHeader 根据 Content-Type 区分SSE，其他响应需要在输出响应头前声明 Trailer
+func (w *redactResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.streaming = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream"); !w.streaming {
+		w.Header().Add("Trailer", RedactCountHeader)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *redactResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *redactResponseWriter) Flush() {
+	if w.streaming && w.count > w.reported {
+		fmt.Fprintf(w.ResponseWriter, "event: redactions\ndata: %d\n\n", w.count)
+		w.reported = w.count
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish 设置 Trailer 中的脱敏次数
+func (w *redactResponseWriter) finish() {
+	if w.wroteHeader && !w.streaming {
+		w.Header().Set(RedactCountHeader, strconv.Itoa(w.count))
+	}
+}
+
+type RedactHandler struct {
+	next http.Handler
+	opt  *RedactOpts
+}
+
+func (h *RedactHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.URL.Path, "/api/") || h.opt.isExempt(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	rw := &redactResponseWriter{ResponseWriter: w, opt: h.opt}
+	h.next.ServeHTTP(rw, r)
+	rw.finish()
+	if rw.count > 0 {
+		slog.Debug("日志脱敏", "path", r.URL.Path, "count", rw.count)
+	}
+}
+
+// NewRedactHandler 对 /api/ 下响应中的日志内容脱敏，没有配置规则时直接返回 next
+func NewRedactHandler(next http.Handler, opt *RedactOpts) http.Handler {
+	if opt == nil || !opt.Enabled() {
+		return next
+	}
+	return &RedactHandler{next: next, opt: opt}
+}
@@ -18,6 +18,11 @@ type LogServer interface {
 	HandleWatch(w http.ResponseWriter, r *http.Request)
 }
 
+// ExtraHandler 模块需要 list/tail/watch 以外的接口时实现，key 为 /api/v1/<模块>/ 后面的路径
+type ExtraHandler interface {
+	Handlers() map[string]http.HandlerFunc
+}
+
 type NewServerFunc func() (LogServer, error)
 
 func Register(future string, fn NewServerFunc) {
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidSelector = errors.New("invalid selector")
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+type Matcher struct {
+	Key   string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func (m *Matcher) Matches(val string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return val == m.Value
+	case MatchNotEqual:
+		return val != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(val)
+	case MatchNotRegexp:
+		return !m.re.MatchString(val)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Key, m.Type, m.Value)
+}
+
+// Selector Prometheus风格的标签选择器，所有条件同时满足才算匹配
+type Selector []*Matcher
+
+// ParseSelector 解析形如 `a=b,c!=d,e=~"f.*",g!~h` 的选择器，可用 {} 包裹，含逗号的值需加引号
+func ParseSelector(s string) (Selector, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+	sel := Selector{}
+	for len(strings.TrimSpace(s)) > 0 {
+		idx := strings.IndexAny(s, "=!")
+		if idx <= 0 {
+			return nil, errors.Wrapf(ErrInvalidSelector, "%q", s)
+		}
+		m := &Matcher{Key: strings.TrimSpace(s[:idx])}
+		s = s[idx:]
+		for _, t := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+			if strings.HasPrefix(s, string(t)) {
+				m.Type = t
+				break
+			}
+		}
+		if len(m.Type) == 0 || len(m.Key) == 0 {
+			return nil, errors.Wrapf(ErrInvalidSelector, "%q", s)
+		}
+		s = strings.TrimLeft(s[len(m.Type):], " ")
+		var err error
+		if m.Value, s, err = cutValue(s); err != nil {
+			return nil, errors.Wrapf(ErrInvalidSelector, "%s: %v", m.Key, err)
+		}
+		if m.Type == MatchRegexp || m.Type == MatchNotRegexp {
+			if m.re, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", m.Value)); err != nil {
+				return nil, errors.Wrapf(ErrInvalidSelector, "%s: %v", m.Key, err)
+			}
+		}
+		sel = append(sel, m)
+	}
+	return sel, nil
+}
+
+func cutValue(s string) (val, rest string, err error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "`") {
+		if val, err = strconv.QuotedPrefix(s); err != nil {
+			return
+		}
+		rest = strings.TrimLeft(s[len(val):], " ")
+		if val, err = strconv.Unquote(val); err != nil {
+			return
+		}
+		if len(rest) > 0 && !strings.HasPrefix(rest, ",") {
+			return "", "", errors.Errorf("unexpected %q", rest)
+		}
+		return val, strings.TrimPrefix(rest, ","), nil
+	}
+	val, rest, _ = strings.Cut(s, ",")
+	return strings.TrimSpace(val), rest, nil
+}
+
+// Matches 判断标签是否满足选择器，不存在的标签视为空字符串
+func (sel Selector) Matches(labels map[string]string) bool {
+	return sel.MatchesFunc(func(key string) string { return labels[key] })
+}
+
+// MatchesFunc 通过 get 获取标签值进行匹配
+func (sel Selector) MatchesFunc(get func(key string) string) bool {
+	for _, m := range sel {
+		if !m.Matches(get(m.Key)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel Selector) String() string {
+	parts := make([]string, len(sel))
+	for idx, m := range sel {
+		parts[idx] = m.String()
+	}
+	return fmt.Sprintf("{%s}", strings.Join(parts, ","))
+}
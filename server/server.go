@@ -22,6 +22,11 @@ func NewHttpMux(prefix string) *http.ServeMux {
 		mux.HandleFunc(fmt.Sprintf("%s/api/v%d/%s/list", prefix, API_VERSION, key), obj.HandleList)
 		mux.HandleFunc(fmt.Sprintf("%s/api/v%d/%s/tail", prefix, API_VERSION, key), obj.HandleTail)
 		mux.HandleFunc(fmt.Sprintf("%s/api/v%d/%s/watch", prefix, API_VERSION, key), obj.HandleWatch)
+		if extra, ok := obj.(ExtraHandler); ok {
+			for path, fn := range extra.Handlers() {
+				mux.HandleFunc(fmt.Sprintf("%s/api/v%d/%s/%s", prefix, API_VERSION, key, path), fn)
+			}
+		}
 		enableFutures = append(enableFutures, key.(string))
 		return true
 	})